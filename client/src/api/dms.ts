@@ -0,0 +1,27 @@
+import { api } from "./auth";
+
+export type DirectRoom = {
+  id: string;
+  other_user_id: string;
+  other_username: string;
+  created_at: string;
+};
+
+export async function fetchDirectRooms(): Promise<DirectRoom[]> {
+  const { data } = await api.get("/api/dms");
+  return data;
+}
+
+// Returns the existing conversation with username, or starts one
+export async function openDirectRoom(username: string): Promise<DirectRoom> {
+  const { data } = await api.post("/api/dms", { username });
+  return data;
+}
+
+export async function blockUser(userId: string): Promise<void> {
+  await api.put(`/api/users/${userId}/block`);
+}
+
+export async function unblockUser(userId: string): Promise<void> {
+  await api.delete(`/api/users/${userId}/block`);
+}
@@ -0,0 +1,23 @@
+import { api } from "./auth";
+import type { Notification } from "../hooks/useChatSocket";
+
+export type NotificationList = {
+  notifications: Notification[];
+  unread: number;
+  next: string | null;
+};
+
+export async function fetchNotifications(
+  params: { unread?: boolean; before?: string; limit?: number } = {},
+): Promise<NotificationList> {
+  const { data } = await api.get("/api/notifications", { params });
+  return data;
+}
+
+// Marks the given notifications read, or all of them when ids is empty
+export async function markNotificationsRead(
+  ids: string[] = [],
+): Promise<{ updated: number }> {
+  const { data } = await api.post("/api/notifications/read", { ids });
+  return data;
+}
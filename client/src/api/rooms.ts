@@ -10,8 +10,13 @@ export type Room = {
   topic_description?: string;
   topic_url?: string;
   topic_source?: string;
+  // Only present when signed in
+  last_read_seq?: number;
+  unread_count?: number;
 };
 
+export type ProfanityAction = "reject" | "mask" | "flag";
+
 export async function fetchRooms(): Promise<Room[]> {
   try {
     const { data } = await api.get("/ws/getRooms");
@@ -26,8 +31,130 @@ export async function fetchRooms(): Promise<Room[]> {
   }
 }
 
-export async function createRoom(name: string): Promise<Room> {
-  const body = { name };
+export async function createRoom(
+  name: string,
+  profanityAction?: ProfanityAction,
+): Promise<Room> {
+  const body = { name, profanity_action: profanityAction };
   const { data } = await api.post("/ws/createRoom", body);
   return data;
 }
+
+export async function markRoomRead(
+  roomId: string,
+  seq: number,
+): Promise<{ room_id: string; last_read_seq: number; unread_count: number }> {
+  const { data } = await api.put(`/api/rooms/${roomId}/read`, { seq });
+  return data;
+}
+
+export type MessagePage = {
+  messages: {
+    id: string;
+    seq: number;
+    room_id: string;
+    user_id?: string;
+    username: string;
+    content: string;
+    system: boolean;
+    bot?: boolean;
+    created_at: string;
+    edited_at?: string;
+    deleted_at?: string;
+    reply_to?: string;
+    reply_count: number;
+    reactions?: { emoji: string; count: number }[];
+  }[];
+  next: string | null;
+  prev: string | null;
+};
+
+export async function fetchMessages(
+  roomId: string,
+  cursor: { before?: string; after?: string; limit?: number } = {},
+): Promise<MessagePage> {
+  const { data } = await api.get(`/api/rooms/${roomId}/messages`, {
+    params: cursor,
+  });
+  return data;
+}
+
+export type Thread = {
+  parent: MessagePage["messages"][number];
+  replies: MessagePage["messages"];
+  has_more: boolean;
+};
+
+export async function fetchThread(
+  roomId: string,
+  messageId: string,
+): Promise<Thread> {
+  const { data } = await api.get(
+    `/api/rooms/${roomId}/messages/${messageId}/thread`,
+  );
+  return data;
+}
+
+export async function editMessage(
+  roomId: string,
+  messageId: string,
+  content: string,
+): Promise<MessagePage["messages"][number]> {
+  const { data } = await api.patch(
+    `/api/rooms/${roomId}/messages/${messageId}`,
+    { content },
+  );
+  return data;
+}
+
+export async function deleteMessage(
+  roomId: string,
+  messageId: string,
+): Promise<MessagePage["messages"][number]> {
+  const { data } = await api.delete(
+    `/api/rooms/${roomId}/messages/${messageId}`,
+  );
+  return data;
+}
+
+export async function addReaction(
+  roomId: string,
+  messageId: string,
+  emoji: string,
+): Promise<{ emoji: string; count: number }> {
+  const { data } = await api.put(
+    `/api/rooms/${roomId}/messages/${messageId}/reactions/${encodeURIComponent(emoji)}`,
+  );
+  return data;
+}
+
+export async function removeReaction(
+  roomId: string,
+  messageId: string,
+  emoji: string,
+): Promise<{ emoji: string; count: number }> {
+  const { data } = await api.delete(
+    `/api/rooms/${roomId}/messages/${messageId}/reactions/${encodeURIComponent(emoji)}`,
+  );
+  return data;
+}
+
+export type SearchResult = MessagePage["messages"][number] & {
+  room_name: string;
+  rank: number;
+  snippet: string;
+};
+
+export async function searchMessages(params: {
+  q: string;
+  room_id?: string;
+  user_id?: string;
+  username?: string;
+  from?: string;
+  to?: string;
+  limit?: number;
+  offset?: number;
+}): Promise<SearchResult[]> {
+  const { data } = await api.get("/api/messages/search", { params });
+  return data;
+}
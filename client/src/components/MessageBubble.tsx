@@ -6,10 +6,11 @@ type Props = {
   username: string;
   userId?: string;
   timestamp?: string;
+  bot?: boolean;
   onUsernameClick?: (userId: string, username: string) => void;
 };
 
-export default function MessageBubble({ text, mine, username, userId, timestamp, onUsernameClick }: Props) {
+export default function MessageBubble({ text, mine, username, userId, timestamp, bot, onUsernameClick }: Props) {
   const formatTime = (timestamp?: string) => {
     if (!timestamp) return "";
     const date = new Date(timestamp);
@@ -37,6 +38,11 @@ export default function MessageBubble({ text, mine, username, userId, timestamp,
           ) : (
             username
           )}
+          {bot && (
+            <span className="ml-1.5 rounded bg-indigo-100 px-1 py-px text-[10px] font-bold uppercase tracking-wide text-indigo-700">
+              Bot
+            </span>
+          )}
         </p>
       )}
       <p className="whitespace-pre-wrap break-words">{text}</p>
@@ -3,19 +3,94 @@ import { useAuth } from "../context/AuthContext";
 import { useNavigate } from "react-router-dom";
 
 export type ChatMessage = {
+  id?: string;
+  seq?: number;
+  code?: string;
   content: string;
   room_id: string;
   username: string;
   user_id?: string;
+  system?: boolean;
+  // Sent by a bot account
+  bot?: boolean;
   timestamp?: string;
+  edited_at?: string;
+  deleted_at?: string;
+  reply_to?: string;
+  reply_count?: number;
+  reactions?: { emoji: string; count: number }[];
+  // Set on our own sends until the server acknowledges them
+  client_id?: string;
+  status?: "sending" | "sent" | "failed";
 };
 
+type PendingSend = { content: string; reply_to?: string };
+
+export type Notification = {
+  id: string;
+  kind: string;
+  room_id: string;
+  room_name: string;
+  message_id?: string;
+  actor_username: string;
+  excerpt: string;
+  link: string;
+  read: boolean;
+  created_at: string;
+};
+
+export type Member = {
+  id: string;
+  username: string;
+};
+
+type Envelope = {
+  v: number;
+  type:
+    | "chat"
+    | "typing"
+    | "presence"
+    | "ack"
+    | "error"
+    | "system"
+    | "command"
+    | "update"
+    | "reaction"
+    | "read"
+    | "notification";
+  id?: string;
+  payload?: any;
+};
+
+const PROTOCOL_VERSION = 1;
+
+// Errors about the user's own message, as opposed to protocol bugs
+const INLINE_ERROR_CODES = [
+  "rate_limited",
+  "muted",
+  "empty_message",
+  "message_too_long",
+  "too_many_lines",
+  "invalid_encoding",
+  "profanity",
+  "read_only",
+];
+
 const WS_URL = import.meta.env.VITE_WEBSOCKET_URL || "wss://server.yappr.chat";
 
 export default function useChatSocket(roomId: string) {
   const { user } = useAuth();
   const [messages, setMessages] = useState<ChatMessage[]>([]);
+  const [members, setMembers] = useState<Member[]>([]);
+  const [typing, setTyping] = useState<Member[]>([]);
+  const [notifications, setNotifications] = useState<Notification[]>([]);
+  // Our read cursor in this room, kept in sync with our other devices
+  const [lastRead, setLastRead] = useState(0);
   const wsRef = useRef<WebSocket | null>(null);
+  const lastSeqRef = useRef(0);
+  // Sends waiting for an ack, by client ID. They are resent after a reconnect
+  // and the server drops the copies it already stored.
+  const pendingRef = useRef(new Map<string, PendingSend>());
   const navigate = useNavigate();
 
   useEffect(() => {
@@ -24,19 +99,170 @@ export default function useChatSocket(roomId: string) {
     let retries = 0;
     let ws: WebSocket;
     let shouldReconnect = true;
+    lastSeqRef.current = 0;
+    setMessages([]);
+    pendingRef.current.clear();
+    setMembers([]);
+    setTyping([]);
+    setLastRead(0);
 
     function connect() {
-      ws = new WebSocket(
-        `${WS_URL}/ws/joinRoom/${roomId}?userId=${user.id}&username=${encodeURIComponent(
-          user.username,
-        )}`,
-      );
+      // Identity comes from the session cookie, not the URL
+      // After a reconnect only the messages we missed are replayed
+      const since = lastSeqRef.current ? `?since=${lastSeqRef.current}` : "";
+      ws = new WebSocket(`${WS_URL}/ws/joinRoom/${roomId}${since}`);
 
-      ws.onopen = () => (retries = 0); // reset back-off on success
+      ws.onopen = () => {
+        retries = 0; // reset back-off on success
+        pendingRef.current.forEach((send, clientId) =>
+          transmit(ws, clientId, send),
+        );
+      };
 
       ws.onmessage = (e) => {
-        const msg = JSON.parse(e.data);
-        setMessages((prev) => [...prev, msg]);
+        const env: Envelope = JSON.parse(e.data);
+        switch (env.type) {
+          case "chat":
+          case "system": {
+            const msg = env.payload as ChatMessage;
+            if (msg.seq && msg.seq > lastSeqRef.current) {
+              lastSeqRef.current = msg.seq;
+            }
+            setMessages((prev) => {
+              const next = msg.reply_to
+                ? prev.map((m) =>
+                    m.id === msg.reply_to
+                      ? { ...m, reply_count: (m.reply_count ?? 0) + 1 }
+                      : m,
+                  )
+                : prev;
+              // Our own send coming back replaces its optimistic copy
+              if (
+                msg.client_id &&
+                next.some((m) => m.client_id === msg.client_id && !m.id)
+              ) {
+                return next.map((m) =>
+                  m.client_id === msg.client_id && !m.id
+                    ? { ...msg, status: "sent" }
+                    : m,
+                );
+              }
+              return [...next, msg];
+            });
+            break;
+          }
+          case "ack": {
+            const { client_id, message_id, seq } = env.payload ?? {};
+            if (!client_id) break;
+            pendingRef.current.delete(client_id);
+            setMessages((prev) =>
+              prev.map((m) =>
+                m.client_id === client_id
+                  ? { ...m, id: m.id ?? message_id, seq: m.seq ?? seq, status: "sent" }
+                  : m,
+              ),
+            );
+            break;
+          }
+          case "update": {
+            // An edit or a deletion tombstone for a message we may already show
+            const msg = env.payload as ChatMessage;
+            setMessages((prev) =>
+              prev.map((m) =>
+                m.id === msg.id
+                  ? {
+                      ...m,
+                      ...msg,
+                      // Deleting a message also clears its reactions
+                      reactions: msg.deleted_at ? [] : m.reactions,
+                    }
+                  : m,
+              ),
+            );
+            break;
+          }
+          case "read": {
+            // Sent to all our connections, including ones in other rooms
+            const { room_id, seq } = env.payload ?? {};
+            if (room_id === roomId && typeof seq === "number") {
+              setLastRead((prev) => Math.max(prev, seq));
+            }
+            break;
+          }
+          case "notification":
+            // Mentions arrive here even when they happened in another room
+            setNotifications((prev) => [env.payload as Notification, ...prev]);
+            break;
+          case "reaction": {
+            // Count is the new total for that emoji on that message
+            const { message_id, emoji, count } = env.payload ?? {};
+            setMessages((prev) =>
+              prev.map((m) => {
+                if (m.id !== message_id) return m;
+                const rest = (m.reactions ?? []).filter((r) => r.emoji !== emoji);
+                const known = m.reactions?.some((r) => r.emoji === emoji);
+                if (count <= 0) return { ...m, reactions: rest };
+                return {
+                  ...m,
+                  reactions: known
+                    ? (m.reactions ?? []).map((r) =>
+                        r.emoji === emoji ? { ...r, count } : r,
+                      )
+                    : [...rest, { emoji, count }],
+                };
+              }),
+            );
+            break;
+          }
+          case "typing": {
+            const { typing: isTyping, user_id, username } = env.payload ?? {};
+            if (!user_id) break;
+            setTyping((prev) => {
+              const rest = prev.filter((m) => m.id !== user_id);
+              return isTyping ? [...rest, { id: user_id, username }] : rest;
+            });
+            break;
+          }
+          case "presence": {
+            const { action, member, members } = env.payload ?? {};
+            if (action === "snapshot") {
+              setMembers(members ?? []);
+            } else if (action === "joined" && member) {
+              setMembers((prev) =>
+                prev.some((m) => m.id === member.id) ? prev : [...prev, member],
+              );
+            } else if (action === "left" && member) {
+              setMembers((prev) => prev.filter((m) => m.id !== member.id));
+            }
+            break;
+          }
+          case "error":
+            if (env.id && pendingRef.current.has(env.id)) {
+              // The send was refused, so retrying it as-is won't help
+              pendingRef.current.delete(env.id);
+              setMessages((prev) =>
+                prev.map((m) =>
+                  m.client_id === env.id && !m.id ? { ...m, status: "failed" } : m,
+                ),
+              );
+            }
+            if (INLINE_ERROR_CODES.includes(env.payload?.code)) {
+              // Show rejections inline so the sender knows why nothing was posted
+              setMessages((prev) => [
+                ...prev,
+                {
+                  code: env.payload.code,
+                  content: env.payload.message,
+                  room_id: roomId,
+                  username: "system",
+                  system: true,
+                },
+              ]);
+              break;
+            }
+            console.warn("Socket error:", env.payload?.code, env.payload?.message);
+            break;
+        }
       };
 
       ws.onclose = (event) => {
@@ -48,6 +274,13 @@ export default function useChatSocket(roomId: string) {
           return;
         }
 
+        if (shouldReconnect && event.code === 1012) {
+          // Server restart: give the new instance a moment to come up
+          retries = 0;
+          setTimeout(connect, 2000);
+          return;
+        }
+
         if (shouldReconnect && retries < 5) {
           retries += 1;
           setTimeout(connect, 500 * retries); // simple back-off
@@ -65,11 +298,87 @@ export default function useChatSocket(roomId: string) {
     };
   }, [roomId, user, navigate]);
 
-  function sendMessage(text: string) {
+  function transmit(ws: WebSocket, clientId: string, send: PendingSend) {
+    if (ws.readyState !== WebSocket.OPEN) return;
+    const env: Envelope = {
+      v: PROTOCOL_VERSION,
+      type: "chat",
+      id: clientId,
+      payload: { ...send, client_id: clientId },
+    };
+    ws.send(JSON.stringify(env));
+  }
+
+  function sendMessage(text: string, replyTo?: string) {
+    const clientId = crypto.randomUUID();
+    const send: PendingSend = { content: text, reply_to: replyTo };
+    pendingRef.current.set(clientId, send);
+    setMessages((prev) => [
+      ...prev,
+      {
+        client_id: clientId,
+        content: text,
+        reply_to: replyTo,
+        room_id: roomId,
+        username: user?.username ?? "",
+        user_id: user?.id,
+        status: "sending",
+      },
+    ]);
+    // If the socket is down this goes out when it reconnects
+    if (wsRef.current) transmit(wsRef.current, clientId, send);
+  }
+
+  // Resends a failed message under the same client ID
+  function retryMessage(clientId: string) {
+    const failed = messages.find(
+      (m) => m.client_id === clientId && m.status === "failed",
+    );
+    if (!failed) return;
+    const send: PendingSend = { content: failed.content, reply_to: failed.reply_to };
+    pendingRef.current.set(clientId, send);
+    setMessages((prev) =>
+      prev.map((m) =>
+        m.client_id === clientId ? { ...m, status: "sending" } : m,
+      ),
+    );
+    if (wsRef.current) transmit(wsRef.current, clientId, send);
+  }
+
+  // The server throttles and expires typing, so this can be called on every keystroke
+  function sendTyping(isTyping: boolean) {
     if (wsRef.current && wsRef.current.readyState === WebSocket.OPEN) {
-      wsRef.current.send(text);
+      const env: Envelope = {
+        v: PROTOCOL_VERSION,
+        type: "typing",
+        payload: { typing: isTyping },
+      };
+      wsRef.current.send(JSON.stringify(env));
+    }
+  }
+
+  // Marks everything up to seq as read. Guests have no cursor to move.
+  function sendRead(seq: number) {
+    if (!user || user.guest || seq <= lastRead) return;
+    if (wsRef.current && wsRef.current.readyState === WebSocket.OPEN) {
+      const env: Envelope = {
+        v: PROTOCOL_VERSION,
+        type: "read",
+        payload: { seq },
+      };
+      wsRef.current.send(JSON.stringify(env));
     }
   }
 
-  return { messages, sendMessage };
+  return {
+    messages,
+    members,
+    typing,
+    notifications,
+    lastRead,
+    sendMessage,
+    retryMessage,
+    sendTyping,
+    sendRead,
+  };
 }
@@ -11,7 +11,7 @@ import { useToast } from "../context/ToastContext";
 export default function ChatPage() {
   const { roomId = "" } = useParams();
   const { user } = useAuth();
-  const { messages, sendMessage } = useChatSocket(roomId);
+  const { messages, sendMessage, sendRead } = useChatSocket(roomId);
   const [input, setInput] = useState("");
   const [roomInfo, setRoomInfo] = useState<any>(null);
   const [profileModal, setProfileModal] = useState<{
@@ -25,6 +25,13 @@ export default function ChatPage() {
     bottomRef.current?.scrollIntoView({ behavior: "smooth" });
   }, [messages.length]);
 
+  // The newest message is on screen, so everything up to it has been read
+  useEffect(() => {
+    const latest = messages.reduce((max, m) => Math.max(max, m.seq ?? 0), 0);
+    if (latest > 0) sendRead(latest);
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [messages.length]);
+
   useEffect(() => {
     async function loadRoomInfo() {
       try {
@@ -118,6 +125,7 @@ export default function ChatPage() {
               username={m.username}
               userId={m.user_id}
               timestamp={m.timestamp}
+              bot={m.bot}
               onUsernameClick={handleUsernameClick}
             />
           </div>
@@ -166,6 +166,11 @@ export default function RoomsPage() {
                             <span className="font-semibold text-indigo-900">
                               {r.name}
                             </span>
+                            {!!r.unread_count && (
+                              <span className="text-xs bg-red-500 text-white px-2 py-0.5 rounded-full">
+                                {r.unread_count}
+                              </span>
+                            )}
                             {r.topic_source && (
                               <span className="text-xs bg-indigo-100 text-indigo-700 px-2 py-0.5 rounded-full">
                                 {r.topic_source}
@@ -221,6 +226,11 @@ export default function RoomsPage() {
                             >
                               {r.name}
                             </span>
+                            {!!r.unread_count && (
+                              <span className="text-xs bg-red-500 text-white px-2 py-0.5 rounded-full">
+                                {r.unread_count}
+                              </span>
+                            )}
                             <span className="text-xs text-gray-500">
                               #{r.id.slice(0, 6)}
                             </span>
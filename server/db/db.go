@@ -9,6 +9,23 @@ import (
 	"github.com/Melkeydev/yappr/util"
 )
 
+// DSN returns the connection string for the configured environment. It is also
+// used by components that need their own connection, like the LISTEN broker.
+func DSN() string {
+	if util.GetEnv("ENVIRONMENT", "dev") == "prod" {
+		return util.GetEnv("CONNECTION_STRING", "")
+	}
+
+	return fmt.Sprintf(
+		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		util.GetEnv("DB_HOST", "localhost"),
+		util.GetEnv("DB_PORT", "5433"),
+		util.GetEnv("DB_USER", "postgres"),
+		util.GetEnv("DB_PASSWORD", "postgres"),
+		util.GetEnv("DB_NAME", "go_chat_db"),
+	)
+}
+
 func NewDatabase() (*sql.DB, error) {
 	env := util.GetEnv("ENVIRONMENT", "dev")
 	
@@ -20,13 +37,9 @@ func NewDatabase() (*sql.DB, error) {
 		dbHost := util.GetEnv("DB_HOST", "localhost")
 		dbPort := util.GetEnv("DB_PORT", "5433")
 		dbUser := util.GetEnv("DB_USER", "postgres")
-		dbPassword := util.GetEnv("DB_PASSWORD", "postgres")
 		dbName := util.GetEnv("DB_NAME", "go_chat_db")
 
-		localDSN := fmt.Sprintf(
-			"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-			dbHost, dbPort, dbUser, dbPassword, dbName,
-		)
+		localDSN := DSN()
 		
 		log.Printf("=== DATABASE CONNECTION (DEVELOPMENT) ===")
 		log.Printf("Environment: %s", env)
@@ -41,7 +54,7 @@ func NewDatabase() (*sql.DB, error) {
 		}
 	} else {
 		// Production environment - pgx handles PostgreSQL URLs natively
-		connStr := util.GetEnv("CONNECTION_STRING", "")
+		connStr := DSN()
 		if connStr == "" {
 			log.Fatal("CONNECTION_STRING must be set in production environment")
 		}
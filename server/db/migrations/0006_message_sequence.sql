@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS last_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT;
+
+-- Number existing messages per room in the order they were sent
+UPDATE messages m
+SET seq = numbered.rn
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY room_id ORDER BY created_at, id) AS rn
+    FROM messages
+) numbered
+WHERE m.id = numbered.id;
+
+UPDATE rooms r
+SET last_seq = COALESCE((SELECT MAX(seq) FROM messages WHERE room_id = r.id), 0);
+
+ALTER TABLE messages ALTER COLUMN seq SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_room_seq ON messages(room_id, seq);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_messages_room_seq;
+ALTER TABLE messages DROP COLUMN seq;
+ALTER TABLE rooms DROP COLUMN last_seq;
+-- +goose StatementEnd
@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Direct rooms are private 1:1 conversations. They never expire and are
+-- only visible to the two users in room_members.
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS kind VARCHAR(16) NOT NULL DEFAULT 'public',
+    ADD COLUMN IF NOT EXISTS direct_key VARCHAR(73);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_rooms_direct_key ON rooms(direct_key) WHERE direct_key IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS room_members (
+    room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (room_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_members_user_id ON room_members(user_id);
+
+CREATE TABLE IF NOT EXISTS user_blocks (
+    blocker_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (blocker_id, blocked_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_blocks;
+DROP TABLE IF EXISTS room_members;
+DELETE FROM rooms WHERE kind = 'direct';
+DROP INDEX IF EXISTS idx_rooms_direct_key;
+ALTER TABLE rooms DROP COLUMN IF EXISTS direct_key;
+ALTER TABLE rooms DROP COLUMN IF EXISTS kind;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- NULL uses the server default (WS_PROFANITY_ACTION)
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS profanity_action VARCHAR(16);
+
+CREATE TABLE IF NOT EXISTS message_flags (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    reason VARCHAR(32) NOT NULL,
+    matches TEXT[] NOT NULL DEFAULT '{}',
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_flags_unreviewed ON message_flags(created_at) WHERE reviewed_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS message_flags;
+ALTER TABLE rooms DROP COLUMN IF EXISTS profanity_action;
+-- +goose StatementEnd
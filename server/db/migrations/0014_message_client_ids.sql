@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Client-generated ID of a send, so retries after a reconnect aren't stored twice
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_id VARCHAR(64);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_user_client_id ON messages(user_id, client_id)
+    WHERE client_id IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_messages_user_client_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS client_id;
+-- +goose StatementEnd
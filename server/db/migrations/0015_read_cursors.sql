@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- How far each registered user has read in each room, shared by all their devices
+CREATE TABLE IF NOT EXISTS room_read_cursors (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    last_read_seq BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, room_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_read_cursors_room ON room_read_cursors(room_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS room_read_cursors;
+-- +goose StatementEnd
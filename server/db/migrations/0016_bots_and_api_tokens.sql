@@ -0,0 +1,42 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Bots are accounts owned by a user. They have no email or password and
+-- only authenticate with API tokens.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS account_type VARCHAR(16) NOT NULL DEFAULT 'user',
+    ADD COLUMN IF NOT EXISTS owner_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    ALTER COLUMN email DROP NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_users_owner_id ON users(owner_id) WHERE owner_id IS NOT NULL;
+
+-- Stored with the message like the username, so history keeps its badge
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Only a SHA-256 of each token is kept; the token itself is shown once
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    prefix VARCHAR(16) NOT NULL,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS api_tokens;
+ALTER TABLE messages DROP COLUMN IF EXISTS is_bot;
+DROP INDEX IF EXISTS idx_users_owner_id;
+DELETE FROM users WHERE account_type = 'bot';
+ALTER TABLE users
+    ALTER COLUMN email SET NOT NULL,
+    DROP COLUMN IF EXISTS owner_id,
+    DROP COLUMN IF EXISTS account_type;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Broker events too large for a NOTIFY payload; the notification carries the row ID
+CREATE TABLE IF NOT EXISTS broker_events (
+    id BIGSERIAL PRIMARY KEY,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_broker_events_created_at ON broker_events(created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS broker_events;
+-- +goose StatementEnd
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/Melkeydev/yappr/internal/api/model"
+	apiTokenService "github.com/Melkeydev/yappr/internal/service/apitokens"
+	"github.com/Melkeydev/yappr/util"
+)
+
+type APITokenHandler struct {
+	apiTokenService *apiTokenService.APITokenService
+}
+
+func NewAPITokenHandler(apiTokenService *apiTokenService.APITokenService) *APITokenHandler {
+	return &APITokenHandler{
+		apiTokenService: apiTokenService,
+	}
+}
+
+// CreateBot adds a bot account owned by the caller
+func (h *APITokenHandler) CreateBot(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	var req model.CreateBotReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid JSON payload")
+		return
+	}
+
+	bot, err := h.apiTokenService.CreateBot(r.Context(), userID, req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	util.WriteJSON(w, http.StatusCreated, bot)
+}
+
+// ListBots returns the caller's bots
+func (h *APITokenHandler) ListBots(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	bots, err := h.apiTokenService.ListBots(r.Context(), userID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	util.WriteJSON(w, http.StatusOK, bots)
+}
+
+// CreateToken issues an API token. The response is the only time it is shown.
+func (h *APITokenHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	var req model.CreateAPITokenReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid JSON payload")
+		return
+	}
+
+	token, err := h.apiTokenService.CreateToken(r.Context(), userID, req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	util.WriteJSON(w, http.StatusCreated, token)
+}
+
+// ListTokens returns the tokens of the caller and their bots
+func (h *APITokenHandler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.apiTokenService.ListTokens(r.Context(), userID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	util.WriteJSON(w, http.StatusOK, tokens)
+}
+
+// RevokeToken revokes a token so it can no longer be used
+func (h *APITokenHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	tokenID, err := uuid.Parse(chi.URLParam(r, "tokenId"))
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid token ID")
+		return
+	}
+
+	if err := h.apiTokenService.RevokeToken(r.Context(), userID, tokenID); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func callerID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userIDStr, ok := r.Context().Value("userID").(string)
+	if !ok {
+		util.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return uuid.Nil, false
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		util.WriteError(w, http.StatusUnauthorized, "invalid user ID")
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+func writeServiceError(w http.ResponseWriter, err error) {
+	if tokenErr, ok := err.(*apiTokenService.APITokenError); ok {
+		switch tokenErr.Code {
+		case "BOT_NOT_FOUND", "TOKEN_NOT_FOUND":
+			util.WriteError(w, http.StatusNotFound, tokenErr.Message)
+		case "NAME_TAKEN":
+			util.WriteError(w, http.StatusConflict, tokenErr.Message)
+		case "FORBIDDEN":
+			util.WriteError(w, http.StatusForbidden, tokenErr.Message)
+		case "INVALID_TOKEN":
+			util.WriteError(w, http.StatusUnauthorized, tokenErr.Message)
+		case "INVALID_NAME", "INVALID_SCOPE", "INVALID_EXPIRY":
+			util.WriteError(w, http.StatusBadRequest, tokenErr.Message)
+		default:
+			util.WriteError(w, http.StatusInternalServerError, "failed to manage API tokens")
+		}
+		return
+	}
+
+	log.Printf("APITokenHandler - Service error: %v", err)
+	util.WriteError(w, http.StatusInternalServerError, "failed to manage API tokens")
+}
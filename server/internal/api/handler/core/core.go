@@ -1,8 +1,10 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
 
@@ -12,6 +14,7 @@ import (
 	"github.com/Melkeydev/yappr/internal/api/model"
 	"github.com/Melkeydev/yappr/internal/filter"
 	roomRepo "github.com/Melkeydev/yappr/internal/repo/room"
+	userRepo "github.com/Melkeydev/yappr/internal/repo/user"
 	"github.com/Melkeydev/yappr/internal/ws"
 	"github.com/Melkeydev/yappr/util"
 )
@@ -19,6 +22,7 @@ import (
 type CoreHandler struct {
 	core            *ws.Core
 	roomRepo        *roomRepo.RoomRepository
+	userRepo        *userRepo.UserRepository
 	roomLimit       int
 	profanityFilter *filter.ProfanityFilter
 }
@@ -35,6 +39,7 @@ func NewCoreHandler(c *ws.Core) *CoreHandler {
 	return &CoreHandler{
 		core:            c,
 		roomRepo:        roomRepo.NewRoomRepository(c.GetDB()),
+		userRepo:        userRepo.NewUserRepository(c.GetDB()),
 		roomLimit:       roomLimit,
 		profanityFilter: filter.NewProfanityFilter(),
 	}
@@ -60,6 +65,18 @@ func (h *CoreHandler) CreateRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// An empty action leaves the room on the server default
+	var profanityAction *string
+	if req.ProfanityAction != "" {
+		action := filter.ParseAction(req.ProfanityAction, "")
+		if action == "" {
+			util.WriteError(w, http.StatusBadRequest, "profanity_action must be reject, mask or flag")
+			return
+		}
+		s := string(action)
+		profanityAction = &s
+	}
+
 	ctx := r.Context()
 
 	// Get user ID from context (if authenticated)
@@ -105,8 +122,9 @@ func (h *CoreHandler) CreateRoom(w http.ResponseWriter, r *http.Request) {
 
 	// Create room in database
 	room := &roomRepo.Room{
-		Name:      req.Name,
-		CreatorID: creatorID,
+		Name:            req.Name,
+		CreatorID:       creatorID,
+		ProfanityAction: profanityAction,
 	}
 	room, err = h.roomRepo.CreateRoom(ctx, room)
 	if err != nil {
@@ -117,17 +135,8 @@ func (h *CoreHandler) CreateRoom(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Room created with ID: %s", room.ID.String())
 
-	// Add to in-memory map
-	h.core.Rooms[room.ID.String()] = &ws.Room{
-		ID:               room.ID.String(),
-		Name:             room.Name,
-		Clients:          make(map[string]*ws.Client),
-		IsPinned:         room.IsPinned,
-		TopicTitle:       room.TopicTitle,
-		TopicDescription: room.TopicDescription,
-		TopicURL:         room.TopicURL,
-		TopicSource:      room.TopicSource,
-	}
+	// Bring the room online so the first join doesn't pay for startup
+	h.core.GetOrCreateRoom(ws.NewRoomInfo(room))
 
 	// Return the room with the database-generated ID
 	resp := model.CreateRoomReq{
@@ -140,36 +149,22 @@ func (h *CoreHandler) CreateRoom(w http.ResponseWriter, r *http.Request) {
 func (h *CoreHandler) JoinRoom(w http.ResponseWriter, r *http.Request) {
 	roomID := chi.URLParam(r, "roomId") // from /ws/{roomId}
 
-	// Verify room exists in database
-	roomUUID, err := uuid.Parse(roomID)
-	if err != nil {
-		util.WriteError(w, http.StatusBadRequest, "invalid room ID")
+	// Reconnecting clients pass the last sequence number they saw
+	since, ok := parseSince(w, r.URL.Query().Get("since"))
+	if !ok {
 		return
 	}
 
-	ctx := r.Context()
-	dbRoom, err := h.roomRepo.GetRoomByID(ctx, roomUUID)
+	// Identity always comes from the session, never from the query string
+	id, responseHeader, err := h.resolveIdentity(r.Context(), r)
 	if err != nil {
-		util.WriteError(w, http.StatusInternalServerError, "failed to verify room")
-		return
-	}
-	if dbRoom == nil {
-		util.WriteError(w, http.StatusNotFound, "room not found or expired")
+		util.WriteError(w, http.StatusInternalServerError, "failed to load user")
 		return
 	}
 
-	// Ensure room exists in memory map
-	if _, exists := h.core.Rooms[roomID]; !exists {
-		h.core.Rooms[roomID] = &ws.Room{
-			ID:               roomID,
-			Name:             dbRoom.Name,
-			Clients:          make(map[string]*ws.Client),
-			IsPinned:         dbRoom.IsPinned,
-			TopicTitle:       dbRoom.TopicTitle,
-			TopicDescription: dbRoom.TopicDescription,
-			TopicURL:         dbRoom.TopicURL,
-			TopicSource:      dbRoom.TopicSource,
-		}
+	room, ok := h.openRoom(w, r, id)
+	if !ok {
+		return
 	}
 
 	var upgrader = websocket.Upgrader{
@@ -182,28 +177,22 @@ func (h *CoreHandler) JoinRoom(w http.ResponseWriter, r *http.Request) {
 		EnableCompression: true,
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		util.WriteError(w, http.StatusBadRequest, "invalid connection upgrade")
 		return
 	}
 
-	q := r.URL.Query()
-	clientID := q.Get("userId")
-	username := q.Get("username")
+	cl := id.newClient(conn, roomID)
+	cl.Since = since
+	cl.IP = clientIP(r)
 
-	cl := &ws.Client{
-		Conn:     conn,
-		Message:  make(chan *ws.Message, 10),
-		ID:       clientID,
-		RoomID:   roomID,
-		Username: username,
+	// Tracked so a graceful shutdown waits for the final frames to go out
+	h.core.Go(cl.WriteMessage)
+	if !room.Register(cl) {
+		return
 	}
-
-	h.core.Register <- cl
-
-	go cl.WriteMessage()
-	cl.ReadMessage(h.core)
+	cl.ReadMessage(room)
 
 }
 
@@ -230,18 +219,26 @@ func (h *CoreHandler) GetRooms(w http.ResponseWriter, r *http.Request) {
 			TopicURL:         room.TopicURL,
 			TopicSource:      room.TopicSource,
 		})
+	}
 
-		// Ensure room exists in memory map
-		if _, exists := h.core.Rooms[room.ID.String()]; !exists {
-			h.core.Rooms[room.ID.String()] = &ws.Room{
-				ID:               room.ID.String(),
-				Name:             room.Name,
-				Clients:          make(map[string]*ws.Client),
-				IsPinned:         room.IsPinned,
-				TopicTitle:       room.TopicTitle,
-				TopicDescription: room.TopicDescription,
-				TopicURL:         room.TopicURL,
-				TopicSource:      room.TopicSource,
+	// Unread counts are per caller, so guests go without
+	if userIDStr, ok := ctx.Value("userID").(string); ok {
+		if userID, err := uuid.Parse(userIDStr); err == nil {
+			roomIDs := make([]uuid.UUID, 0, len(dbRooms))
+			for _, room := range dbRooms {
+				roomIDs = append(roomIDs, room.ID)
+			}
+
+			states, err := h.roomRepo.GetReadStates(ctx, userID, roomIDs)
+			if err != nil {
+				// The room list is still useful without them
+				log.Printf("CoreHandler.GetRooms - Failed to load read states for %s: %v", userID, err)
+			} else {
+				for i, room := range dbRooms {
+					state := states[room.ID]
+					rooms[i].LastReadSeq = &state.LastReadSeq
+					rooms[i].UnreadCount = &state.UnreadCount
+				}
 			}
 		}
 	}
@@ -250,21 +247,154 @@ func (h *CoreHandler) GetRooms(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *CoreHandler) GetClients(w http.ResponseWriter, r *http.Request) {
-	var clients []model.ClientRes
 	roomID := chi.URLParam(r, "roomId") // from /ws/{roomId}
 
-	if _, ok := h.core.Rooms[roomID]; !ok {
-		clients = make([]model.ClientRes, 0)
-		util.WriteJSON(w, http.StatusOK, clients)
+	// Members may be connected through other instances even if the room isn't live here
+	members := h.core.Members(roomID)
+	if len(members) == 0 {
+		util.WriteJSON(w, http.StatusOK, make([]model.ClientRes, 0))
 		return
 	}
 
-	for _, c := range h.core.Rooms[roomID].Clients {
+	kind := ""
+	if room, ok := h.core.GetRoom(roomID); ok {
+		kind = room.Kind
+	} else if roomUUID, err := uuid.Parse(roomID); err == nil {
+		dbRoom, err := h.roomRepo.GetRoomByID(r.Context(), roomUUID)
+		if err != nil {
+			util.WriteError(w, http.StatusInternalServerError, "failed to verify room")
+			return
+		}
+		if dbRoom == nil {
+			util.WriteJSON(w, http.StatusOK, make([]model.ClientRes, 0))
+			return
+		}
+		kind = dbRoom.Kind
+	}
+
+	if kind == roomRepo.KindDirect {
+		var viewerID *uuid.UUID
+		if userIDStr, ok := r.Context().Value("userID").(string); ok {
+			if uid, err := uuid.Parse(userIDStr); err == nil {
+				viewerID = &uid
+			}
+		}
+		roomUUID, _ := uuid.Parse(roomID)
+		allowed, err := h.roomRepo.CanAccessRoom(r.Context(), &roomRepo.Room{ID: roomUUID, Kind: kind}, viewerID)
+		if err != nil {
+			util.WriteError(w, http.StatusInternalServerError, "failed to verify room")
+			return
+		}
+		if !allowed {
+			util.WriteError(w, http.StatusNotFound, "room not found or expired")
+			return
+		}
+	}
+
+	clients := make([]model.ClientRes, 0, len(members))
+	for _, m := range members {
 		clients = append(clients, model.ClientRes{
-			ID:       c.ID,
-			Username: c.Username,
+			ID:       m.ID,
+			Username: m.Username,
 		})
 	}
 
 	util.WriteJSON(w, http.StatusOK, clients)
 }
+
+// openRoom verifies the room in the path exists and that the caller may use
+// it, then brings it online. It writes the error response when they can't.
+func (h *CoreHandler) openRoom(w http.ResponseWriter, r *http.Request, id *identity) (*ws.Room, bool) {
+	roomUUID, err := uuid.Parse(chi.URLParam(r, "roomId"))
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid room ID")
+		return nil, false
+	}
+
+	ctx := r.Context()
+	dbRoom, err := h.roomRepo.GetRoomByID(ctx, roomUUID)
+	if err != nil {
+		util.WriteError(w, http.StatusInternalServerError, "failed to verify room")
+		return nil, false
+	}
+	if dbRoom == nil {
+		util.WriteError(w, http.StatusNotFound, "room not found or expired")
+		return nil, false
+	}
+
+	if dbRoom.Kind == roomRepo.KindDirect {
+		if status, msg := h.checkDirectAccess(ctx, dbRoom, id); status != http.StatusOK {
+			util.WriteError(w, status, msg)
+			return nil, false
+		}
+	}
+
+	return h.core.GetOrCreateRoom(ws.NewRoomInfo(dbRoom)), true
+}
+
+// parseSince reads the last sequence number a resuming client saw
+func parseSince(w http.ResponseWriter, s string) (int64, bool) {
+	if s == "" {
+		return 0, true
+	}
+	since, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || since < 0 {
+		util.WriteError(w, http.StatusBadRequest, "invalid since parameter")
+		return 0, false
+	}
+	return since, true
+}
+
+// checkDirectAccess only lets the two members of a direct room in, and neither
+// of them once one has blocked the other
+func (h *CoreHandler) checkDirectAccess(ctx context.Context, room *roomRepo.Room, id *identity) (int, string) {
+	if id.Guest {
+		return http.StatusNotFound, "room not found or expired"
+	}
+	userID, err := uuid.Parse(id.ID)
+	if err != nil {
+		return http.StatusNotFound, "room not found or expired"
+	}
+
+	members, err := h.roomRepo.GetRoomMemberIDs(ctx, room.ID)
+	if err != nil {
+		log.Printf("JoinRoom - Failed to load members of room %s: %v", room.ID, err)
+		return http.StatusInternalServerError, "failed to verify room"
+	}
+
+	isMember := false
+	var otherID uuid.UUID
+	for _, m := range members {
+		if m == userID {
+			isMember = true
+		} else {
+			otherID = m
+		}
+	}
+	if !isMember {
+		// Don't reveal that the conversation exists
+		return http.StatusNotFound, "room not found or expired"
+	}
+
+	if otherID != uuid.Nil {
+		blocked, err := h.userRepo.IsBlockedEitherWay(ctx, userID, otherID)
+		if err != nil {
+			log.Printf("JoinRoom - Failed to check blocks in room %s: %v", room.ID, err)
+			return http.StatusInternalServerError, "failed to verify room"
+		}
+		if blocked {
+			return http.StatusForbidden, "this conversation is blocked"
+		}
+	}
+
+	return http.StatusOK, ""
+}
+
+// clientIP returns the caller's address without its port. RealIP has already
+// replaced RemoteAddr with the forwarded address when behind a proxy.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
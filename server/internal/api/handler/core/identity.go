@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/Melkeydev/yappr/internal/api/model"
+	"github.com/Melkeydev/yappr/internal/ws"
+	authmiddleware "github.com/Melkeydev/yappr/middleware"
+	"github.com/Melkeydev/yappr/util"
+)
+
+const (
+	guestCookieName = "guest"
+	guestTokenTTL   = 24 * time.Hour
+)
+
+// identity is the server-assigned identity of a socket connection
+type identity struct {
+	ID       string
+	Username string
+	Guest    bool
+	Bot      bool
+
+	// ReadOnly is set for API tokens that can't post messages
+	ReadOnly bool
+}
+
+type guestClaims struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	jwt.RegisteredClaims
+}
+
+// resolveIdentity determines who is joining a room. Registered users are taken from the
+// JWT set by OptionalJWTAuth; everyone else gets a signed guest identity that survives
+// reconnects. The returned header carries the guest cookie when a new one was issued.
+// A signed-in user whose account can't be loaded gets an error rather than being
+// demoted to a guest.
+func (h *CoreHandler) resolveIdentity(ctx context.Context, r *http.Request) (*identity, http.Header, error) {
+	if userIDStr, ok := ctx.Value("userID").(string); ok {
+		if uid, err := uuid.Parse(userIDStr); err == nil {
+			user, err := h.userRepo.GetUserByID(ctx, uid)
+			if err != nil {
+				log.Printf("CoreHandler.resolveIdentity - Failed to load user %s: %v", userIDStr, err)
+				return nil, nil, err
+			}
+			if user != nil {
+				return &identity{
+					ID:       user.ID.String(),
+					Username: user.Username,
+					Bot:      user.IsBot(),
+					ReadOnly: !authmiddleware.HasScope(ctx, model.ScopeMessagesWrite),
+				}, nil, nil
+			}
+		}
+	}
+
+	if guest := parseGuestCookie(r); guest != nil {
+		return guest, nil, nil
+	}
+
+	guest := newGuestIdentity()
+	header := http.Header{}
+	if cookie := guestCookie(guest); cookie != nil {
+		header.Add("Set-Cookie", cookie.String())
+	}
+	return guest, header, nil
+}
+
+// newClient creates the room client for this identity. conn is nil for
+// Server-Sent Event streams and HTTP senders.
+func (id *identity) newClient(conn *websocket.Conn, roomID string) *ws.Client {
+	cl := ws.NewClient(conn, id.ID, roomID, id.Username, id.Guest)
+	cl.Bot = id.Bot
+	cl.ReadOnly = id.ReadOnly
+	return cl
+}
+
+func newGuestIdentity() *identity {
+	id := uuid.New()
+	return &identity{
+		ID:       model.GuestUsernamePrefix + id.String(),
+		Username: model.GuestUsernamePrefix + id.String()[:8],
+		Guest:    true,
+	}
+}
+
+func parseGuestCookie(r *http.Request) *identity {
+	cookie, err := r.Cookie(guestCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+
+	secretKey := util.GetEnv("secretKey", "")
+	if secretKey == "" {
+		return nil
+	}
+
+	var claims guestClaims
+	token, err := jwt.ParseWithClaims(cookie.Value, &claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(secretKey), nil
+	})
+	if err != nil || !token.Valid {
+		return nil
+	}
+
+	if !model.IsReservedUsername(claims.ID) || !model.IsReservedUsername(claims.Username) {
+		return nil
+	}
+
+	return &identity{ID: claims.ID, Username: claims.Username, Guest: true}
+}
+
+func guestCookie(guest *identity) *http.Cookie {
+	secretKey := util.GetEnv("secretKey", "")
+	if secretKey == "" {
+		return nil
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, guestClaims{
+		ID:       guest.ID,
+		Username: guest.Username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(guestTokenTTL)),
+		},
+	})
+
+	ss, err := token.SignedString([]byte(secretKey))
+	if err != nil {
+		log.Printf("CoreHandler.guestCookie - Failed to sign guest token: %v", err)
+		return nil
+	}
+
+	// Mirrors util.SetSecureCookie; the upgrader writes its own response headers
+	cookie := &http.Cookie{
+		Name:     guestCookieName,
+		Value:    ss,
+		Path:     "/",
+		MaxAge:   int(guestTokenTTL.Seconds()),
+		HttpOnly: true,
+	}
+	if util.GetEnv("ENVIRONMENT", "dev") == "prod" {
+		cookie.Domain = ".yappr.chat"
+		cookie.Secure = true
+		cookie.SameSite = http.SameSiteNoneMode
+	} else {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+
+	return cookie
+}
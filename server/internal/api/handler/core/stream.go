@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Melkeydev/yappr/internal/api/model"
+	"github.com/Melkeydev/yappr/internal/ws"
+	"github.com/Melkeydev/yappr/util"
+)
+
+// StreamEvents serves a room's events as Server-Sent Events for clients that
+// can't hold a WebSocket. Identity and access work as in JoinRoom, and a
+// reconnect resumes after Last-Event-ID (or ?since=).
+func (h *CoreHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	sinceStr := r.Header.Get("Last-Event-ID")
+	if sinceStr == "" {
+		sinceStr = r.URL.Query().Get("since")
+	}
+	since, ok := parseSince(w, sinceStr)
+	if !ok {
+		return
+	}
+
+	id, responseHeader, err := h.resolveIdentity(r.Context(), r)
+	if err != nil {
+		util.WriteError(w, http.StatusInternalServerError, "failed to load user")
+		return
+	}
+
+	room, ok := h.openRoom(w, r, id)
+	if !ok {
+		return
+	}
+
+	header := w.Header()
+	for k, v := range responseHeader {
+		header[k] = v
+	}
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// Stop nginx from buffering the stream
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	cl := id.newClient(nil, chi.URLParam(r, "roomId"))
+	cl.Since = since
+	cl.IP = clientIP(r)
+
+	if !room.Register(cl) {
+		return
+	}
+	cl.StreamEvents(r.Context(), room, w)
+}
+
+// SendMessage posts a chat message over HTTP. It goes through the same
+// validation, profanity filter and send limits as the socket, and responds
+// once the message is stored.
+func (h *CoreHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
+	var req model.SendMessageReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid JSON payload")
+		return
+	}
+
+	id, responseHeader, err := h.resolveIdentity(r.Context(), r)
+	if err != nil {
+		util.WriteError(w, http.StatusInternalServerError, "failed to load user")
+		return
+	}
+
+	room, ok := h.openRoom(w, r, id)
+	if !ok {
+		return
+	}
+	for k, v := range responseHeader {
+		w.Header()[k] = v
+	}
+
+	// Each request is its own connection, so only the per-user limit carries over between them
+	cl := id.newClient(nil, chi.URLParam(r, "roomId"))
+	cl.IP = clientIP(r)
+
+	ack, err := room.Send(r.Context(), cl, ws.ChatPayload{
+		Content:  req.Content,
+		ReplyTo:  req.ReplyTo,
+		ClientID: req.ClientID,
+	})
+	if err != nil {
+		writeSendError(w, err)
+		return
+	}
+
+	status := http.StatusCreated
+	if ack.Duplicate {
+		status = http.StatusOK
+	}
+	util.WriteJSON(w, status, model.SendMessageRes{
+		ClientID:  ack.ClientID,
+		MessageID: ack.MessageID,
+		Seq:       ack.Seq,
+		Duplicate: ack.Duplicate,
+	})
+}
+
+// writeSendError maps a rejected send to an HTTP response
+func writeSendError(w http.ResponseWriter, err error) {
+	var protoErr *ws.ProtocolError
+	if !errors.As(err, &protoErr) {
+		if errors.Is(err, ws.ErrRoomClosed) {
+			util.WriteError(w, http.StatusServiceUnavailable, "room is unavailable, try again")
+			return
+		}
+		// The caller went away
+		util.WriteError(w, http.StatusRequestTimeout, "message was not confirmed in time")
+		return
+	}
+
+	switch protoErr.Code {
+	case ws.ErrCodeReadOnly:
+		util.WriteError(w, http.StatusForbidden, protoErr.Message)
+	case ws.ErrCodeRateLimited, ws.ErrCodeMuted:
+		util.WriteError(w, http.StatusTooManyRequests, protoErr.Message)
+	case ws.ErrCodeRoomBusy:
+		util.WriteError(w, http.StatusServiceUnavailable, protoErr.Message)
+	case ws.ErrCodePersistFailed:
+		util.WriteError(w, http.StatusInternalServerError, protoErr.Message)
+	default:
+		util.WriteError(w, http.StatusBadRequest, protoErr.Message)
+	}
+}
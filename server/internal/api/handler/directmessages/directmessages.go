@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/Melkeydev/yappr/internal/api/model"
+	dmService "github.com/Melkeydev/yappr/internal/service/directmessages"
+	"github.com/Melkeydev/yappr/util"
+)
+
+type DirectMessageHandler struct {
+	dmService *dmService.DirectMessageService
+}
+
+func NewDirectMessageHandler(dmService *dmService.DirectMessageService) *DirectMessageHandler {
+	return &DirectMessageHandler{
+		dmService: dmService,
+	}
+}
+
+// OpenConversation starts or resumes a direct conversation with another user
+func (h *DirectMessageHandler) OpenConversation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	var req model.OpenDirectRoomReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid JSON payload")
+		return
+	}
+
+	room, err := h.dmService.OpenConversation(r.Context(), userID, req.Username)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	util.WriteJSON(w, http.StatusOK, room)
+}
+
+// ListConversations returns the caller's direct conversations
+func (h *DirectMessageHandler) ListConversations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	rooms, err := h.dmService.ListConversations(r.Context(), userID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	util.WriteJSON(w, http.StatusOK, rooms)
+}
+
+// Block stops a user from messaging the caller
+func (h *DirectMessageHandler) Block(w http.ResponseWriter, r *http.Request) {
+	h.setBlocked(w, r, true)
+}
+
+// Unblock lifts the caller's block on a user
+func (h *DirectMessageHandler) Unblock(w http.ResponseWriter, r *http.Request) {
+	h.setBlocked(w, r, false)
+}
+
+func (h *DirectMessageHandler) setBlocked(w http.ResponseWriter, r *http.Request, blocked bool) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	otherID, err := uuid.Parse(chi.URLParam(r, "userId"))
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	if blocked {
+		err = h.dmService.Block(r.Context(), userID, otherID)
+	} else {
+		err = h.dmService.Unblock(r.Context(), userID, otherID)
+	}
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func callerID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userIDStr, ok := r.Context().Value("userID").(string)
+	if !ok {
+		util.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return uuid.Nil, false
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		util.WriteError(w, http.StatusUnauthorized, "invalid user ID")
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// writeServiceError maps direct message service errors to HTTP responses
+func writeServiceError(w http.ResponseWriter, err error) {
+	if dmErr, ok := err.(*dmService.DirectMessageError); ok {
+		switch dmErr.Code {
+		case "USER_NOT_FOUND":
+			util.WriteError(w, http.StatusNotFound, dmErr.Message)
+		case "INVALID_TARGET":
+			util.WriteError(w, http.StatusBadRequest, dmErr.Message)
+		case "BLOCKED":
+			util.WriteError(w, http.StatusForbidden, dmErr.Message)
+		default:
+			util.WriteError(w, http.StatusInternalServerError, "direct message request failed")
+		}
+		return
+	}
+
+	log.Printf("DirectMessageHandler - Service error: %v", err)
+	util.WriteError(w, http.StatusInternalServerError, "direct message request failed")
+}
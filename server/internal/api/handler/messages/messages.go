@@ -0,0 +1,357 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/Melkeydev/yappr/internal/api/model"
+	messageService "github.com/Melkeydev/yappr/internal/service/messages"
+	"github.com/Melkeydev/yappr/util"
+)
+
+type MessageHandler struct {
+	messageService *messageService.MessageService
+}
+
+func NewMessageHandler(messageService *messageService.MessageService) *MessageHandler {
+	return &MessageHandler{
+		messageService: messageService,
+	}
+}
+
+// ListMessages returns a page of a room's history using before/after cursors
+func (h *MessageHandler) ListMessages(w http.ResponseWriter, r *http.Request) {
+	roomID, err := uuid.Parse(chi.URLParam(r, "roomId"))
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid room ID")
+		return
+	}
+
+	q := messageService.PageQuery{RoomID: roomID, ViewerID: viewerID(r)}
+	params := r.URL.Query()
+
+	if before := params.Get("before"); before != "" {
+		id, err := uuid.Parse(before)
+		if err != nil {
+			util.WriteError(w, http.StatusBadRequest, "invalid before cursor")
+			return
+		}
+		q.Before = &id
+	}
+
+	if after := params.Get("after"); after != "" {
+		id, err := uuid.Parse(after)
+		if err != nil {
+			util.WriteError(w, http.StatusBadRequest, "invalid after cursor")
+			return
+		}
+		q.After = &id
+	}
+
+	if limitStr := params.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			util.WriteError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		q.Limit = limit
+	}
+
+	page, err := h.messageService.ListMessages(r.Context(), q)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	util.WriteJSON(w, http.StatusOK, page)
+}
+
+// Search runs a full-text search over messages in active rooms
+func (h *MessageHandler) Search(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := messageService.SearchQuery{
+		Query:    params.Get("q"),
+		Username: params.Get("username"),
+		ViewerID: viewerID(r),
+	}
+
+	if roomID := params.Get("room_id"); roomID != "" {
+		id, err := uuid.Parse(roomID)
+		if err != nil {
+			util.WriteError(w, http.StatusBadRequest, "invalid room_id")
+			return
+		}
+		q.RoomID = &id
+	}
+
+	if userID := params.Get("user_id"); userID != "" {
+		id, err := uuid.Parse(userID)
+		if err != nil {
+			util.WriteError(w, http.StatusBadRequest, "invalid user_id")
+			return
+		}
+		q.UserID = &id
+	}
+
+	for name, dst := range map[string]**time.Time{"from": &q.From, "to": &q.To} {
+		if v := params.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				util.WriteError(w, http.StatusBadRequest, "invalid "+name+" timestamp, expected RFC3339")
+				return
+			}
+			*dst = &t
+		}
+	}
+
+	if limitStr := params.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			util.WriteError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		q.Limit = limit
+	}
+
+	if offsetStr := params.Get("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			util.WriteError(w, http.StatusBadRequest, "invalid offset")
+			return
+		}
+		q.Offset = offset
+	}
+
+	results, err := h.messageService.Search(r.Context(), q)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	util.WriteJSON(w, http.StatusOK, results)
+}
+
+// GetThread returns a message with its direct replies
+func (h *MessageHandler) GetThread(w http.ResponseWriter, r *http.Request) {
+	roomID, err := uuid.Parse(chi.URLParam(r, "roomId"))
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid room ID")
+		return
+	}
+	messageID, err := uuid.Parse(chi.URLParam(r, "messageId"))
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid message ID")
+		return
+	}
+
+	limit := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			util.WriteError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	thread, err := h.messageService.GetThread(r.Context(), roomID, messageID, viewerID(r), limit)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	util.WriteJSON(w, http.StatusOK, thread)
+}
+
+// EditMessage replaces the content of the caller's own message
+func (h *MessageHandler) EditMessage(w http.ResponseWriter, r *http.Request) {
+	roomID, messageID, userID, ok := messageParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req model.EditMessageReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid JSON payload")
+		return
+	}
+
+	msg, err := h.messageService.EditMessage(r.Context(), roomID, messageID, userID, req.Content)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	util.WriteJSON(w, http.StatusOK, msg)
+}
+
+// DeleteMessage tombstones a message owned by the caller, or any message in a room they created
+func (h *MessageHandler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	roomID, messageID, userID, ok := messageParams(w, r)
+	if !ok {
+		return
+	}
+
+	msg, err := h.messageService.DeleteMessage(r.Context(), roomID, messageID, userID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	util.WriteJSON(w, http.StatusOK, msg)
+}
+
+// AddReaction adds the caller's emoji reaction to a message
+func (h *MessageHandler) AddReaction(w http.ResponseWriter, r *http.Request) {
+	h.react(w, r, true)
+}
+
+// RemoveReaction removes the caller's emoji reaction from a message
+func (h *MessageHandler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	h.react(w, r, false)
+}
+
+func (h *MessageHandler) react(w http.ResponseWriter, r *http.Request, add bool) {
+	roomID, messageID, userID, ok := messageParams(w, r)
+	if !ok {
+		return
+	}
+
+	// Emoji arrive percent-encoded in the path
+	emoji, err := url.PathUnescape(chi.URLParam(r, "emoji"))
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid emoji")
+		return
+	}
+
+	var res any
+	if add {
+		res, err = h.messageService.AddReaction(r.Context(), roomID, messageID, userID, emoji)
+	} else {
+		res, err = h.messageService.RemoveReaction(r.Context(), roomID, messageID, userID, emoji)
+	}
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	util.WriteJSON(w, http.StatusOK, res)
+}
+
+// ListEdits returns the previous versions of an edited message
+func (h *MessageHandler) ListEdits(w http.ResponseWriter, r *http.Request) {
+	roomID, err := uuid.Parse(chi.URLParam(r, "roomId"))
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid room ID")
+		return
+	}
+	messageID, err := uuid.Parse(chi.URLParam(r, "messageId"))
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid message ID")
+		return
+	}
+
+	edits, err := h.messageService.ListEdits(r.Context(), roomID, messageID, viewerID(r))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	util.WriteJSON(w, http.StatusOK, edits)
+}
+
+// MarkRead moves the caller's read cursor in a room
+func (h *MessageHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	userID := viewerID(r)
+	if userID == nil {
+		util.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	roomID, err := uuid.Parse(chi.URLParam(r, "roomId"))
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid room ID")
+		return
+	}
+
+	var req model.MarkRoomReadReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid JSON payload")
+		return
+	}
+
+	state, err := h.messageService.MarkRead(r.Context(), roomID, *userID, req.Seq)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	util.WriteJSON(w, http.StatusOK, state)
+}
+
+// messageParams reads the room and message IDs from the path and the caller from the JWT
+func messageParams(w http.ResponseWriter, r *http.Request) (roomID, messageID, userID uuid.UUID, ok bool) {
+	userIDStr, found := r.Context().Value("userID").(string)
+	if !found {
+		util.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		util.WriteError(w, http.StatusUnauthorized, "invalid user ID")
+		return
+	}
+
+	roomID, err = uuid.Parse(chi.URLParam(r, "roomId"))
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid room ID")
+		return
+	}
+	messageID, err = uuid.Parse(chi.URLParam(r, "messageId"))
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "invalid message ID")
+		return
+	}
+
+	return roomID, messageID, userID, true
+}
+
+// viewerID returns the signed-in caller, if any
+func viewerID(r *http.Request) *uuid.UUID {
+	userIDStr, ok := r.Context().Value("userID").(string)
+	if !ok {
+		return nil
+	}
+	id, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+// writeServiceError maps message service errors to HTTP responses
+func writeServiceError(w http.ResponseWriter, err error) {
+	if msgErr, ok := err.(*messageService.MessageError); ok {
+		switch msgErr.Code {
+		case "ROOM_NOT_FOUND", "MESSAGE_NOT_FOUND":
+			util.WriteError(w, http.StatusNotFound, msgErr.Message)
+		case "MESSAGE_DELETED", "TOO_MANY_REACTIONS":
+			util.WriteError(w, http.StatusConflict, msgErr.Message)
+		case "FORBIDDEN":
+			util.WriteError(w, http.StatusForbidden, msgErr.Message)
+		case "INVALID_CURSOR", "CONFLICTING_CURSORS", "INVALID_QUERY", "INVALID_CONTENT":
+			util.WriteError(w, http.StatusBadRequest, msgErr.Message)
+		default:
+			util.WriteError(w, http.StatusInternalServerError, "failed to load messages")
+		}
+		return
+	}
+
+	log.Printf("MessageHandler - Service error: %v", err)
+	util.WriteError(w, http.StatusInternalServerError, "failed to load messages")
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Melkeydev/yappr/internal/api/model"
+	notificationService "github.com/Melkeydev/yappr/internal/service/notifications"
+	"github.com/Melkeydev/yappr/util"
+)
+
+type NotificationHandler struct {
+	notificationService *notificationService.NotificationService
+}
+
+func NewNotificationHandler(notificationService *notificationService.NotificationService) *NotificationHandler {
+	return &NotificationHandler{
+		notificationService: notificationService,
+	}
+}
+
+// ListNotifications returns the caller's notifications, newest first
+func (h *NotificationHandler) ListNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	params := r.URL.Query()
+	unreadOnly := params.Get("unread") == "true"
+
+	var before *time.Time
+	if v := params.Get("before"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			util.WriteError(w, http.StatusBadRequest, "invalid before cursor")
+			return
+		}
+		before = &t
+	}
+
+	limit := 0
+	if limitStr := params.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			util.WriteError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	res, err := h.notificationService.ListNotifications(r.Context(), userID, unreadOnly, before, limit)
+	if err != nil {
+		util.WriteError(w, http.StatusInternalServerError, "failed to load notifications")
+		return
+	}
+
+	util.WriteJSON(w, http.StatusOK, res)
+}
+
+// MarkRead marks the listed notifications read, or all of them when none are listed
+func (h *NotificationHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(w, r)
+	if !ok {
+		return
+	}
+
+	var req model.MarkNotificationsReadReq
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteError(w, http.StatusBadRequest, "invalid JSON payload")
+			return
+		}
+	}
+
+	ids := make([]uuid.UUID, 0, len(req.IDs))
+	for _, idStr := range req.IDs {
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			util.WriteError(w, http.StatusBadRequest, "invalid notification ID")
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	updated, err := h.notificationService.MarkRead(r.Context(), userID, ids)
+	if err != nil {
+		util.WriteError(w, http.StatusInternalServerError, "failed to mark notifications read")
+		return
+	}
+
+	util.WriteJSON(w, http.StatusOK, map[string]int64{"updated": updated})
+}
+
+func callerID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userIDStr, ok := r.Context().Value("userID").(string)
+	if !ok {
+		util.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return uuid.Nil, false
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		util.WriteError(w, http.StatusUnauthorized, "invalid user ID")
+		return uuid.Nil, false
+	}
+	return userID, true
+}
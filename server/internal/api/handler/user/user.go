@@ -125,6 +125,11 @@ func (h *UserHandler) UpdateUsername(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if model.IsReservedUsername(req.Username) {
+		util.WriteError(w, http.StatusBadRequest, "usernames starting with \""+model.GuestUsernamePrefix+"\" are reserved")
+		return
+	}
+
 	// Check for profanity in username
 	if h.profanityFilter.ContainsProfanity(req.Username) {
 		log.Printf("UpdateUsername - Username blocked for inappropriate content: %s", req.Username)
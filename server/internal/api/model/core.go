@@ -3,8 +3,9 @@ package model
 import "time"
 
 type CreateRoomReq struct {
-	ID   string `json:"id,omitempty"`
-	Name string `json:"name"`
+	ID              string `json:"id,omitempty"`
+	Name            string `json:"name"`
+	ProfanityAction string `json:"profanity_action,omitempty"`
 }
 
 type ClientRes struct {
@@ -22,4 +23,121 @@ type RoomRes struct {
 	TopicDescription *string    `json:"topic_description,omitempty"`
 	TopicURL         *string    `json:"topic_url,omitempty"`
 	TopicSource      *string    `json:"topic_source,omitempty"`
+
+	// Only set for signed-in callers
+	LastReadSeq *int64 `json:"last_read_seq,omitempty"`
+	UnreadCount *int   `json:"unread_count,omitempty"`
+}
+
+type MessageRes struct {
+	ID         string        `json:"id"`
+	Seq        int64         `json:"seq"`
+	RoomID     string        `json:"room_id"`
+	UserID     *string       `json:"user_id,omitempty"`
+	Username   string        `json:"username"`
+	Content    string        `json:"content"`
+	System     bool          `json:"system"`
+	Bot        bool          `json:"bot,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	EditedAt   *time.Time    `json:"edited_at,omitempty"`
+	DeletedAt  *time.Time    `json:"deleted_at,omitempty"`
+	ReplyTo    *string       `json:"reply_to,omitempty"`
+	ReplyCount int           `json:"reply_count"`
+	Reactions  []ReactionRes `json:"reactions,omitempty"`
+}
+
+type ReactionRes struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+type MessagePageRes struct {
+	Messages []MessageRes `json:"messages"`
+	Next     *string      `json:"next"`
+	Prev     *string      `json:"prev"`
+}
+
+type SearchResultRes struct {
+	MessageRes
+	RoomName string  `json:"room_name"`
+	Rank     float64 `json:"rank"`
+	Snippet  string  `json:"snippet"`
+}
+
+type EditMessageReq struct {
+	Content string `json:"content"`
+}
+
+type MessageEditRes struct {
+	Content  string    `json:"content"`
+	EditedAt time.Time `json:"edited_at"`
+}
+
+type ThreadRes struct {
+	Parent  MessageRes   `json:"parent"`
+	Replies []MessageRes `json:"replies"`
+	HasMore bool         `json:"has_more"`
+}
+
+type NotificationRes struct {
+	ID            string    `json:"id"`
+	Kind          string    `json:"kind"`
+	RoomID        string    `json:"room_id"`
+	RoomName      string    `json:"room_name"`
+	MessageID     *string   `json:"message_id,omitempty"`
+	ActorUsername string    `json:"actor_username"`
+	Excerpt       string    `json:"excerpt"`
+	Link          string    `json:"link"`
+	Read          bool      `json:"read"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type NotificationListRes struct {
+	Notifications []NotificationRes `json:"notifications"`
+	Unread        int               `json:"unread"`
+	Next          *string           `json:"next"`
+}
+
+type MarkNotificationsReadReq struct {
+	IDs []string `json:"ids"`
+}
+
+type OpenDirectRoomReq struct {
+	Username string `json:"username"`
+}
+
+type DirectRoomRes struct {
+	ID            string    `json:"id"`
+	OtherUserID   string    `json:"other_user_id"`
+	OtherUsername string    `json:"other_username"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// MarkRoomReadReq moves the caller's read cursor in a room to Seq
+type MarkRoomReadReq struct {
+	Seq int64 `json:"seq"`
+}
+
+// ReadStateRes is the caller's read cursor in a room and what's unread after it
+type ReadStateRes struct {
+	RoomID      string `json:"room_id"`
+	LastReadSeq int64  `json:"last_read_seq"`
+	UnreadCount int    `json:"unread_count"`
+}
+
+// SendMessageReq posts a chat message over HTTP. ClientID makes retries safe
+// the same way it does on the socket.
+type SendMessageReq struct {
+	Content  string `json:"content"`
+	ReplyTo  string `json:"reply_to,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// SendMessageRes identifies the stored message. Duplicate is set when the
+// client ID had already been sent.
+type SendMessageRes struct {
+	ClientID  string `json:"client_id,omitempty"`
+	MessageID string `json:"message_id"`
+	Seq       int64  `json:"seq"`
+	Duplicate bool   `json:"duplicate"`
 }
@@ -1,5 +1,19 @@
 package model
 
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// GuestUsernamePrefix is reserved for server-issued guest identities
+const GuestUsernamePrefix = "guest-"
+
+// IsReservedUsername reports whether a username could be mistaken for a guest identity
+func IsReservedUsername(username string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(username)), GuestUsernamePrefix)
+}
+
 type RequestCreateUser struct {
 	Username string
 	Email    string
@@ -16,3 +30,55 @@ type ResponseLoginUser struct {
 	ID          string `json:"id"`
 	Username    string `json:"username"`
 }
+
+// Scopes an API token can be granted
+const (
+	ScopeRoomsRead     = "rooms:read"
+	ScopeMessagesWrite = "messages:write"
+	ScopeRoomsCreate   = "rooms:create"
+)
+
+var APIScopes = []string{ScopeRoomsRead, ScopeMessagesWrite, ScopeRoomsCreate}
+
+// IsAPIScope reports whether scope can be granted to an API token
+func IsAPIScope(scope string) bool {
+	return slices.Contains(APIScopes, scope)
+}
+
+type CreateBotReq struct {
+	Username string `json:"username"`
+}
+
+type BotRes struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateAPITokenReq creates a token for the caller, or for one of their bots
+// when BotID is set. Tokens without an expiry last until revoked.
+type CreateAPITokenReq struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	BotID         string   `json:"bot_id,omitempty"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty"`
+}
+
+type APITokenRes struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Username   string     `json:"username"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreatedAPITokenRes carries the token itself, which is only shown once
+type CreatedAPITokenRes struct {
+	APITokenRes
+	Token string `json:"token"`
+}
@@ -0,0 +1,39 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Event kinds exchanged between instances
+const (
+	// KindEnvelope carries a socket envelope that should reach every member of a room
+	KindEnvelope = "envelope"
+	// KindRoomClosed tells other instances to disconnect a room's local clients
+	KindRoomClosed = "room_closed"
+	// KindUserEnvelope carries a socket envelope for every connection of one user
+	KindUserEnvelope = "user_envelope"
+	// KindPresence carries the members the origin instance has in a room
+	KindPresence = "presence"
+	// KindPresenceSync asks every instance to publish its presence right away
+	KindPresenceSync = "presence_sync"
+)
+
+// Event is a room event published by one yappr instance for all the others
+type Event struct {
+	Origin string          `json:"origin"`
+	RoomID string          `json:"room_id,omitempty"`
+	UserID string          `json:"user_id,omitempty"`
+	Kind   string          `json:"kind"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// Handler receives events published by any instance, including the caller's own
+type Handler func(*Event)
+
+// Broker fans room events out across server instances
+type Broker interface {
+	Publish(ctx context.Context, ev *Event) error
+	Subscribe(handler Handler)
+	Close() error
+}
@@ -0,0 +1,77 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+const memorySubscriberBuffer = 1024
+
+// MemoryBroker delivers events within a single process. It is what a single
+// node runs with, and lets several cores share one bus in tests.
+type MemoryBroker struct {
+	mu     sync.RWMutex
+	subs   []chan *Event
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, ev *Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return errors.New("broker closed")
+	}
+
+	for _, sub := range b.subs {
+		select {
+		case sub <- ev:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	sub := make(chan *Event, memorySubscriberBuffer)
+	b.subs = append(b.subs, sub)
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for ev := range sub {
+			handler(ev)
+		}
+	}()
+}
+
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	for _, sub := range b.subs {
+		close(sub)
+	}
+	b.subs = nil
+	b.mu.Unlock()
+
+	b.wg.Wait()
+	return nil
+}
@@ -0,0 +1,201 @@
+package broker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// NotifyChannel is the Postgres channel all instances LISTEN on
+	NotifyChannel = "yappr_events"
+
+	// Postgres rejects NOTIFY payloads of 8000 bytes or more
+	maxNotifyPayload = 7999
+
+	// How long a spilled event is kept for listeners to load it
+	spillRetention = 5 * time.Minute
+	// Deadline for a listener loading a spilled event
+	spillLoadTimeout = 5 * time.Second
+)
+
+// notification is what goes over NOTIFY: the event itself, or for events
+// too large for a payload, the ID of the broker_events row holding it
+type notification struct {
+	*Event
+	Ref int64 `json:"ref,omitempty"`
+}
+
+// PostgresBroker fans events out across instances with LISTEN/NOTIFY
+type PostgresBroker struct {
+	db       *sql.DB
+	listener *pq.Listener
+
+	mu       sync.RWMutex
+	handlers []Handler
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewPostgresBroker publishes through db and listens on a dedicated connection opened from dsn
+func NewPostgresBroker(db *sql.DB, dsn string) (*PostgresBroker, error) {
+	listener := pq.NewListener(dsn, 2*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("PostgresBroker - Listener event %d: %v", ev, err)
+		}
+	})
+
+	if err := listener.Listen(NotifyChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("listen on %s: %w", NotifyChannel, err)
+	}
+
+	b := &PostgresBroker{
+		db:       db,
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+
+	b.wg.Add(1)
+	go b.listen()
+
+	return b, nil
+}
+
+func (b *PostgresBroker) Publish(ctx context.Context, ev *Event) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+
+	if len(payload) > maxNotifyPayload {
+		return b.publishSpilled(ctx, payload)
+	}
+
+	if _, err := b.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, NotifyChannel, string(payload)); err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+	return nil
+}
+
+// publishSpilled stores an oversized event and notifies its row ID. The row is
+// committed before the notification is delivered, so listeners can load it.
+func (b *PostgresBroker) publishSpilled(ctx context.Context, payload []byte) error {
+	query := `
+		WITH ev AS (
+			INSERT INTO broker_events (payload) VALUES ($2) RETURNING id
+		)
+		SELECT pg_notify($1, json_build_object('ref', id)::text) FROM ev
+	`
+	if _, err := b.db.ExecContext(ctx, query, NotifyChannel, string(payload)); err != nil {
+		return fmt.Errorf("notify spilled event: %w", err)
+	}
+
+	// Every listener has had ample time to load older events
+	_, err := b.db.ExecContext(ctx,
+		`DELETE FROM broker_events WHERE created_at < NOW() - make_interval(secs => $1)`,
+		spillRetention.Seconds(),
+	)
+	if err != nil {
+		log.Printf("PostgresBroker.publishSpilled - Failed to prune spilled events: %v", err)
+	}
+	return nil
+}
+
+// loadSpilled reads an event stored by publishSpilled
+func (b *PostgresBroker) loadSpilled(id int64) (*Event, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), spillLoadTimeout)
+	defer cancel()
+
+	var payload string
+	err := b.db.QueryRowContext(ctx, `SELECT payload FROM broker_events WHERE id = $1`, id).Scan(&payload)
+	if err != nil {
+		return nil, fmt.Errorf("load spilled event %d: %w", id, err)
+	}
+
+	var ev Event
+	if err := json.Unmarshal([]byte(payload), &ev); err != nil {
+		return nil, fmt.Errorf("decode spilled event %d: %w", id, err)
+	}
+	return &ev, nil
+}
+
+func (b *PostgresBroker) Subscribe(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *PostgresBroker) Close() error {
+	select {
+	case <-b.done:
+		return nil
+	default:
+	}
+
+	close(b.done)
+	err := b.listener.Close()
+	b.wg.Wait()
+	return err
+}
+
+func (b *PostgresBroker) listen() {
+	defer b.wg.Done()
+
+	// Ping periodically so a dead connection is noticed and re-established
+	ticker := time.NewTicker(90 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+
+		case n, ok := <-b.listener.Notify:
+			if !ok {
+				return
+			}
+			// A nil notification means the connection was re-established and
+			// events sent in between were lost
+			if n == nil {
+				log.Printf("PostgresBroker - Listener reconnected, some events may have been missed")
+				continue
+			}
+
+			note := notification{Event: &Event{}}
+			if err := json.Unmarshal([]byte(n.Extra), &note); err != nil {
+				log.Printf("PostgresBroker - Dropping malformed event: %v", err)
+				continue
+			}
+			ev := note.Event
+			if note.Ref != 0 {
+				var err error
+				if ev, err = b.loadSpilled(note.Ref); err != nil {
+					log.Printf("PostgresBroker - Dropping event: %v", err)
+					continue
+				}
+			}
+
+			b.mu.RLock()
+			handlers := b.handlers
+			b.mu.RUnlock()
+
+			for _, handler := range handlers {
+				handler(ev)
+			}
+
+		case <-ticker.C:
+			go func() {
+				if err := b.listener.Ping(); err != nil {
+					log.Printf("PostgresBroker - Listener ping failed: %v", err)
+				}
+			}()
+		}
+	}
+}
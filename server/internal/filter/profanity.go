@@ -2,7 +2,9 @@ package filter
 
 import (
 	"regexp"
+	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 // ProfanityFilter contains the filtering logic for inappropriate content
@@ -13,8 +15,19 @@ type ProfanityFilter struct {
 
 // NewProfanityFilter creates a new profanity filter with a comprehensive list of banned words
 func NewProfanityFilter() *ProfanityFilter {
+	return newFilter(getBannedWords())
+}
+
+// NewChatFilter creates a filter for chat messages. Its list leaves out the
+// everyday words the name filter also blocks, such as "bot" or "hate", since
+// conversation uses them all the time.
+func NewChatFilter() *ProfanityFilter {
+	return newFilter(getChatBannedWords())
+}
+
+func newFilter(words []string) *ProfanityFilter {
 	filter := &ProfanityFilter{
-		bannedWords: getBannedWords(),
+		bannedWords: words,
 	}
 	
 	// Compile regex patterns for banned words (case-insensitive, with word boundaries)
@@ -120,4 +133,132 @@ func getBannedWords() []string {
 		"fuk", "shyt", "btch", "azz", "phuck", "biatch",
 		"n1gger", "n1gga", "f4ggot", "f4g", "sh1t", "fck",
 	}
-}
\ No newline at end of file
+}
+
+// getChatBannedWords returns the profanity and slurs masked in chat messages
+func getChatBannedWords() []string {
+	return []string{
+		// Common profanity
+		"fuck", "shit", "bitch", "asshole", "bastard",
+		"dickhead", "jackass", "dumbass", "bullshit",
+
+		// Stronger profanity
+		"motherfucker", "cocksucker", "son of a bitch", "piece of shit",
+
+		// Racist slurs and terms
+		"nigger", "nigga", "spic", "wetback", "chink", "gook",
+		"kike", "hymie", "raghead", "towelhead", "sand nigger",
+		"beaner", "christ killer",
+
+		// Homophobic slurs
+		"faggot", "fag", "dyke", "tranny",
+
+		// Disability slurs
+		"retard", "retarded", "spastic",
+
+		// Harassment
+		"kill yourself", "kys",
+
+		// Common variations and misspellings
+		"fuk", "shyt", "btch", "phuck", "biatch",
+		"n1gger", "n1gga", "f4ggot", "f4g", "sh1t", "fck",
+	}
+}
+
+// Match is a span of text that matched a banned word. Start and End are byte
+// offsets into the original text.
+type Match struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// FindMatches returns every profane span in text, ordered by position with
+// overlapping spans merged
+func (pf *ProfanityFilter) FindMatches(text string) []Match {
+	var spans [][]int
+	for _, pattern := range pf.patterns {
+		spans = append(spans, pattern.FindAllStringIndex(text, -1)...)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i][0] < spans[j][0]
+	})
+
+	matches := []Match{{Start: spans[0][0], End: spans[0][1]}}
+	for _, span := range spans[1:] {
+		last := &matches[len(matches)-1]
+		if span[0] <= last.End {
+			if span[1] > last.End {
+				last.End = span[1]
+			}
+			continue
+		}
+		matches = append(matches, Match{Start: span[0], End: span[1]})
+	}
+
+	for i := range matches {
+		matches[i].Text = text[matches[i].Start:matches[i].End]
+	}
+	return matches
+}
+
+// Mask replaces every character inside the matched spans with an asterisk
+func Mask(text string, matches []Match) string {
+	if len(matches) == 0 {
+		return text
+	}
+
+	var b strings.Builder
+	b.Grow(len(text))
+	prev := 0
+	for _, m := range matches {
+		b.WriteString(text[prev:m.Start])
+		b.WriteString(strings.Repeat("*", utf8.RuneCountInString(text[m.Start:m.End])))
+		prev = m.End
+	}
+	b.WriteString(text[prev:])
+	return b.String()
+}
+
+// Action is what happens to a chat message that contains profanity
+type Action string
+
+const (
+	// ActionReject refuses the message
+	ActionReject Action = "reject"
+	// ActionMask replaces the matched words with asterisks
+	ActionMask Action = "mask"
+	// ActionFlag lets the message through unchanged and flags it for review
+	ActionFlag Action = "flag"
+)
+
+// ParseAction returns the action named by s, or def if s isn't one
+func ParseAction(s string, def Action) Action {
+	switch a := Action(strings.ToLower(strings.TrimSpace(s))); a {
+	case ActionReject, ActionMask, ActionFlag:
+		return a
+	}
+	return def
+}
+
+// Moderate applies action to text. It returns the text to send, the matches
+// found, and whether the message must be rejected.
+func (pf *ProfanityFilter) Moderate(text string, action Action) (string, []Match, bool) {
+	matches := pf.FindMatches(text)
+	if len(matches) == 0 {
+		return text, nil, false
+	}
+
+	switch action {
+	case ActionReject:
+		return text, matches, true
+	case ActionFlag:
+		return text, matches, false
+	default:
+		return Mask(text, matches), matches, false
+	}
+}
@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket configuration: Rate tokens are added per second, up
+// to Burst tokens saved up for short spikes
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Bucket is a single token bucket. It is not safe for concurrent use.
+type Bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a bucket that starts full
+func NewBucket(limit Limit) *Bucket {
+	return &Bucket{limit: limit, tokens: float64(limit.Burst)}
+}
+
+// Allow takes a token if one is available at now
+func (b *Bucket) Allow(now time.Time) bool {
+	if !b.Ready(now) {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Ready reports whether a token is available at now without taking it
+func (b *Bucket) Ready(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+		if burst := float64(b.limit.Burst); b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+	return b.tokens >= 1
+}
+
+// idle reports whether the bucket has refilled completely and can be forgotten
+func (b *Bucket) idle(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst)
+}
+
+// Limiter is a set of buckets keyed by caller, safe for concurrent use
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*Bucket
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: make(map[string]*Bucket)}
+}
+
+// Allow takes a token from key's bucket, creating it with limit on first use
+func (l *Limiter) Allow(key string, limit Limit) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = NewBucket(limit)
+		l.buckets[key] = b
+	}
+	return b.Allow(time.Now())
+}
+
+// Prune drops buckets that have refilled, so idle callers don't accumulate
+func (l *Limiter) Prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for key, b := range l.buckets {
+		if b.idle(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
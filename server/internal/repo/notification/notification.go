@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// KindMention is a notification for being @mentioned in a room
+const KindMention = "mention"
+
+type Notification struct {
+	ID            uuid.UUID  `json:"id"`
+	UserID        uuid.UUID  `json:"user_id"`
+	Kind          string     `json:"kind"`
+	RoomID        uuid.UUID  `json:"room_id"`
+	RoomName      string     `json:"room_name"`
+	MessageID     *uuid.UUID `json:"message_id,omitempty"`
+	ActorID       *uuid.UUID `json:"actor_id,omitempty"`
+	ActorUsername string     `json:"actor_username"`
+	Excerpt       string     `json:"excerpt"`
+	ReadAt        *time.Time `json:"read_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type NotificationRepository struct {
+	db *sql.DB
+}
+
+func NewNotificationRepository(db *sql.DB) *NotificationRepository {
+	return &NotificationRepository{db: db}
+}
+
+// CreateNotification stores a notification, filling in its ID and creation time
+func (r *NotificationRepository) CreateNotification(ctx context.Context, n *Notification) error {
+	query := `
+		INSERT INTO notifications (user_id, kind, room_id, room_name, message_id, actor_id, actor_username, excerpt)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRowContext(ctx, query,
+		n.UserID, n.Kind, n.RoomID, n.RoomName, n.MessageID, n.ActorID, n.ActorUsername, n.Excerpt,
+	).Scan(&n.ID, &n.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert notification: %w", err)
+	}
+	return nil
+}
+
+// ListNotifications returns a user's notifications, newest first. A non-nil
+// before only returns notifications created earlier than that time.
+func (r *NotificationRepository) ListNotifications(ctx context.Context, userID uuid.UUID, unreadOnly bool, before *time.Time, limit int) ([]*Notification, error) {
+	query := `
+		SELECT id, user_id, kind, room_id, room_name, message_id, actor_id, actor_username, excerpt, read_at, created_at
+		FROM notifications
+		WHERE user_id = $1
+		  AND ($2 = FALSE OR read_at IS NULL)
+		  AND ($3::timestamptz IS NULL OR created_at < $3)
+		ORDER BY created_at DESC
+		LIMIT $4
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID, unreadOnly, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var notifications []*Notification
+	for rows.Next() {
+		var n Notification
+		err := rows.Scan(
+			&n.ID,
+			&n.UserID,
+			&n.Kind,
+			&n.RoomID,
+			&n.RoomName,
+			&n.MessageID,
+			&n.ActorID,
+			&n.ActorUsername,
+			&n.Excerpt,
+			&n.ReadAt,
+			&n.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scan notification: %w", err)
+		}
+		notifications = append(notifications, &n)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate notifications: %w", err)
+	}
+
+	return notifications, nil
+}
+
+// CountUnread returns how many notifications the user hasn't read
+func (r *NotificationRepository) CountUnread(ctx context.Context, userID uuid.UUID) (int, error) {
+	query := `SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count unread notifications: %w", err)
+	}
+	return count, nil
+}
+
+// MarkRead marks the given notifications as read. Only the user's own
+// notifications are touched; an empty ids list marks all of them.
+func (r *NotificationRepository) MarkRead(ctx context.Context, userID uuid.UUID, ids []uuid.UUID) (int64, error) {
+	query := `
+		UPDATE notifications SET read_at = NOW()
+		WHERE user_id = $1 AND read_at IS NULL
+	`
+	args := []any{userID}
+
+	if len(ids) > 0 {
+		strIDs := make([]string, 0, len(ids))
+		for _, id := range ids {
+			strIDs = append(strIDs, id.String())
+		}
+		query += ` AND id = ANY($2::uuid[])`
+		args = append(args, pq.Array(strIDs))
+	}
+
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("mark notifications read: %w", err)
+	}
+	return result.RowsAffected()
+}
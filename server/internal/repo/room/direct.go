@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// DirectRoom is a direct conversation as seen by one of its two members
+type DirectRoom struct {
+	Room
+	OtherUserID   uuid.UUID `json:"other_user_id"`
+	OtherUsername string    `json:"other_username"`
+}
+
+// directKey identifies the conversation between two users regardless of who started it
+func directKey(a, b uuid.UUID) string {
+	if a.String() > b.String() {
+		a, b = b, a
+	}
+	return a.String() + ":" + b.String()
+}
+
+// GetOrCreateDirectRoom returns the direct room shared by two users, creating
+// it and its memberships the first time
+func (r *RoomRepository) GetOrCreateDirectRoom(ctx context.Context, a, b uuid.UUID) (*Room, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin direct room tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	key := directKey(a, b)
+	room := &Room{Name: "Direct message", Kind: KindDirect}
+
+	// The no-op update makes RETURNING yield the existing row on conflict
+	query := `
+		INSERT INTO rooms (name, kind, direct_key, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (direct_key) WHERE direct_key IS NOT NULL
+		DO UPDATE SET direct_key = EXCLUDED.direct_key
+		RETURNING id, created_at, expires_at
+	`
+	err = tx.QueryRowContext(ctx, query, room.Name, KindDirect, key, DirectRoomExpiry).
+		Scan(&room.ID, &room.CreatedAt, &room.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("insert direct room: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO room_members (room_id, user_id)
+		VALUES ($1, $2), ($1, $3)
+		ON CONFLICT DO NOTHING
+	`, room.ID, a, b)
+	if err != nil {
+		return nil, fmt.Errorf("insert direct room members: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit direct room: %w", err)
+	}
+
+	return room, nil
+}
+
+// GetDirectRoom returns the direct room shared by two users, or nil if they never talked
+func (r *RoomRepository) GetDirectRoom(ctx context.Context, a, b uuid.UUID) (*Room, error) {
+	query := `
+		SELECT id, name, kind, created_at, expires_at
+		FROM rooms
+		WHERE direct_key = $1
+	`
+
+	var room Room
+	err := r.db.QueryRowContext(ctx, query, directKey(a, b)).
+		Scan(&room.ID, &room.Name, &room.Kind, &room.CreatedAt, &room.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("query direct room: %w", err)
+	}
+	return &room, nil
+}
+
+// ListDirectRooms returns the user's direct conversations, most recently created first
+func (r *RoomRepository) ListDirectRooms(ctx context.Context, userID uuid.UUID) ([]*DirectRoom, error) {
+	query := `
+		SELECT r.id, r.name, r.kind, r.created_at, r.expires_at, u.id, u.username
+		FROM rooms r
+		INNER JOIN room_members me ON me.room_id = r.id AND me.user_id = $1
+		INNER JOIN room_members other ON other.room_id = r.id AND other.user_id <> $1
+		INNER JOIN users u ON u.id = other.user_id
+		WHERE r.kind = 'direct'
+		ORDER BY r.created_at DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query direct rooms: %w", err)
+	}
+	defer rows.Close()
+
+	var rooms []*DirectRoom
+	for rows.Next() {
+		var dr DirectRoom
+		err := rows.Scan(
+			&dr.ID,
+			&dr.Name,
+			&dr.Kind,
+			&dr.CreatedAt,
+			&dr.ExpiresAt,
+			&dr.OtherUserID,
+			&dr.OtherUsername,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scan direct room: %w", err)
+		}
+		rooms = append(rooms, &dr)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate direct rooms: %w", err)
+	}
+
+	return rooms, nil
+}
+
+// GetRoomMemberIDs returns the members of a private room
+func (r *RoomRepository) GetRoomMemberIDs(ctx context.Context, roomID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT user_id FROM room_members WHERE room_id = $1`, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("query room members: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan room member: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate room members: %w", err)
+	}
+
+	return ids, nil
+}
+
+// CanAccessRoom reports whether a viewer may read and join a room. Public rooms
+// are open to everyone; private rooms only to their members.
+func (r *RoomRepository) CanAccessRoom(ctx context.Context, room *Room, viewerID *uuid.UUID) (bool, error) {
+	if room.Kind != KindDirect {
+		return true, nil
+	}
+	if viewerID == nil {
+		return false, nil
+	}
+
+	var member bool
+	err := r.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)`,
+		room.ID, *viewerID,
+	).Scan(&member)
+	if err != nil {
+		return false, fmt.Errorf("check room membership: %w", err)
+	}
+	return member, nil
+}
@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Reasons a message was flagged for review
+const (
+	FlagReasonProfanity = "profanity"
+)
+
+// FlagMessage queues a message for moderator review
+func (r *RoomRepository) FlagMessage(ctx context.Context, messageID uuid.UUID, reason string, matches []string) error {
+	query := `INSERT INTO message_flags (message_id, reason, matches) VALUES ($1, $2, $3)`
+	if matches == nil {
+		matches = []string{}
+	}
+	if _, err := r.db.ExecContext(ctx, query, messageID, reason, pq.Array(matches)); err != nil {
+		return fmt.Errorf("flag message: %w", err)
+	}
+	return nil
+}
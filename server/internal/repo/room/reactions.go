@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ReactionCount is how many users reacted to a message with one emoji
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// AddReaction records a user's reaction. It reports false without adding
+// anything when the message already has maxDistinct different emoji and this
+// one isn't among them. Adding a reaction twice is a no-op.
+func (r *RoomRepository) AddReaction(ctx context.Context, messageID, userID uuid.UUID, emoji string, maxDistinct int) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin reaction tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Concurrent reactions would otherwise each see room for one more emoji
+	// and push the message over the limit together
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM messages WHERE id = $1 FOR UPDATE`, messageID); err != nil {
+		return false, fmt.Errorf("lock message: %w", err)
+	}
+
+	query := `
+		INSERT INTO message_reactions (message_id, user_id, emoji)
+		SELECT $1, $2, $3
+		WHERE EXISTS (
+			SELECT 1 FROM message_reactions WHERE message_id = $1 AND emoji = $3
+		) OR (
+			SELECT COUNT(DISTINCT emoji) FROM message_reactions WHERE message_id = $1
+		) < $4
+		ON CONFLICT (message_id, user_id, emoji) DO NOTHING
+	`
+
+	res, err := tx.ExecContext(ctx, query, messageID, userID, emoji, maxDistinct)
+	if err != nil {
+		return false, fmt.Errorf("add reaction: %w", err)
+	}
+	added, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("add reaction: %w", err)
+	}
+
+	// Nothing inserted: either a duplicate or the message is at its limit
+	exists := added > 0
+	if !exists {
+		err = tx.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3)`,
+			messageID, userID, emoji,
+		).Scan(&exists)
+		if err != nil {
+			return false, fmt.Errorf("check reaction: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit reaction: %w", err)
+	}
+	return exists, nil
+}
+
+// RemoveReaction deletes a user's reaction, if present
+func (r *RoomRepository) RemoveReaction(ctx context.Context, messageID, userID uuid.UUID, emoji string) error {
+	query := `DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`
+
+	if _, err := r.db.ExecContext(ctx, query, messageID, userID, emoji); err != nil {
+		return fmt.Errorf("remove reaction: %w", err)
+	}
+	return nil
+}
+
+// CountReaction returns how many users reacted to a message with the given emoji
+func (r *RoomRepository) CountReaction(ctx context.Context, messageID uuid.UUID, emoji string) (int, error) {
+	query := `SELECT COUNT(*) FROM message_reactions WHERE message_id = $1 AND emoji = $2`
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, messageID, emoji).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count reaction: %w", err)
+	}
+	return count, nil
+}
+
+// LoadReactions fills in the aggregated reaction counts of each message
+func (r *RoomRepository) LoadReactions(ctx context.Context, messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(messages))
+	byID := make(map[uuid.UUID]*Message, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.ID.String())
+		byID[m.ID] = m
+	}
+
+	query := `
+		SELECT message_id, emoji, COUNT(*)
+		FROM message_reactions
+		WHERE message_id = ANY($1::uuid[])
+		GROUP BY message_id, emoji
+		ORDER BY message_id, MIN(created_at)
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("query reactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID uuid.UUID
+		var rc ReactionCount
+		if err := rows.Scan(&messageID, &rc.Emoji, &rc.Count); err != nil {
+			return fmt.Errorf("scan reaction: %w", err)
+		}
+		if m, ok := byID[messageID]; ok {
+			m.Reactions = append(m.Reactions, rc)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate reactions: %w", err)
+	}
+	return nil
+}
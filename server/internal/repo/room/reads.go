@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ReadState is a user's read cursor in a room and the messages after it
+type ReadState struct {
+	LastReadSeq int64 `json:"last_read_seq"`
+	UnreadCount int   `json:"unread_count"`
+}
+
+// MarkRead moves the user's read cursor in a room forward to seq, capped at
+// the room's latest message. Cursors never move backwards, so it returns the
+// cursor as stored.
+func (r *RoomRepository) MarkRead(ctx context.Context, userID, roomID uuid.UUID, seq int64) (int64, error) {
+	query := `
+		INSERT INTO room_read_cursors (user_id, room_id, last_read_seq)
+		SELECT $1, id, LEAST($3, last_seq) FROM rooms WHERE id = $2
+		ON CONFLICT (user_id, room_id) DO UPDATE
+		SET last_read_seq = GREATEST(room_read_cursors.last_read_seq, EXCLUDED.last_read_seq),
+		    updated_at = NOW()
+		RETURNING last_read_seq
+	`
+
+	var cursor int64
+	err := r.db.QueryRowContext(ctx, query, userID, roomID, seq).Scan(&cursor)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errors.New("room not found")
+		}
+		return 0, fmt.Errorf("mark room read: %w", err)
+	}
+
+	return cursor, nil
+}
+
+// GetReadStates returns the user's read state in each of the given rooms.
+// Unread counts leave out system messages, deleted messages and the user's own.
+func (r *RoomRepository) GetReadStates(ctx context.Context, userID uuid.UUID, roomIDs []uuid.UUID) (map[uuid.UUID]ReadState, error) {
+	states := make(map[uuid.UUID]ReadState, len(roomIDs))
+	if len(roomIDs) == 0 {
+		return states, nil
+	}
+
+	strIDs := make([]string, 0, len(roomIDs))
+	for _, id := range roomIDs {
+		strIDs = append(strIDs, id.String())
+	}
+
+	query := `
+		SELECT r.id, COALESCE(c.last_read_seq, 0),
+		       (SELECT COUNT(*) FROM messages m
+		        WHERE m.room_id = r.id AND m.seq > COALESCE(c.last_read_seq, 0)
+		          AND m.deleted_at IS NULL AND NOT m.is_system
+		          AND m.user_id IS DISTINCT FROM $1)
+		FROM rooms r
+		LEFT JOIN room_read_cursors c ON c.room_id = r.id AND c.user_id = $1
+		WHERE r.id = ANY($2::uuid[])
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID, pq.Array(strIDs))
+	if err != nil {
+		return nil, fmt.Errorf("query read states: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		var state ReadState
+		if err := rows.Scan(&id, &state.LastReadSeq, &state.UnreadCount); err != nil {
+			return nil, fmt.Errorf("scan read state: %w", err)
+		}
+		states[id] = state
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate read states: %w", err)
+	}
+
+	return states, nil
+}
@@ -8,11 +8,23 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+// Room kinds
+const (
+	KindPublic = "public"
+	KindDirect = "direct"
+)
+
+// DirectRoomExpiry is stored as the expiry of direct rooms so the checks that
+// treat a room as live keep working while cleanup never reaches them
+var DirectRoomExpiry = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
 type Room struct {
 	ID               uuid.UUID  `json:"id"`
 	Name             string     `json:"name"`
+	Kind             string     `json:"kind"`
 	CreatorID        *uuid.UUID `json:"creator_id"`
 	CreatedAt        time.Time  `json:"created_at"`
 	ExpiresAt        time.Time  `json:"expires_at"`
@@ -22,6 +34,9 @@ type Room struct {
 	TopicURL         *string    `json:"topic_url,omitempty"`
 	TopicSource      *string    `json:"topic_source,omitempty"`
 	TopicUpdatedAt   *time.Time `json:"topic_updated_at,omitempty"`
+
+	// ProfanityAction overrides the server's default chat filter action when set
+	ProfanityAction *string `json:"profanity_action,omitempty"`
 }
 
 type Message struct {
@@ -31,7 +46,29 @@ type Message struct {
 	Username  string     `json:"username"`
 	Content   string     `json:"content"`
 	IsSystem  bool       `json:"is_system"`
+	IsBot     bool       `json:"is_bot"`
+	Seq       int64      `json:"seq"`
 	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	ReplyTo   *uuid.UUID `json:"reply_to,omitempty"`
+
+	// ClientID is the sender's idempotency key, only set when storing
+	ClientID *string `json:"client_id,omitempty"`
+
+	// ReplyCount is the number of live replies, filled in when reading history
+	ReplyCount int `json:"reply_count"`
+
+	// Reactions is filled in by LoadReactions
+	Reactions []ReactionCount `json:"reactions,omitempty"`
+}
+
+// MessageEdit is a previous version of an edited message
+type MessageEdit struct {
+	ID        uuid.UUID `json:"id"`
+	MessageID uuid.UUID `json:"message_id"`
+	Content   string    `json:"content"`
+	EditedAt  time.Time `json:"edited_at"`
 }
 
 type RoomRepository struct {
@@ -49,14 +86,14 @@ func (r *RoomRepository) CreateRoom(ctx context.Context, room *Room) (*Room, err
 	if room.IsPinned {
 		// For pinned rooms, we can set a custom expires_at time
 		query = `
-			INSERT INTO rooms (name, creator_id, is_pinned, topic_title, topic_description, topic_url, topic_source, topic_updated_at, expires_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			INSERT INTO rooms (name, creator_id, is_pinned, topic_title, topic_description, topic_url, topic_source, topic_updated_at, expires_at, profanity_action)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 			RETURNING id, created_at, expires_at
 		`
 		err = r.db.QueryRowContext(ctx, query, 
 			room.Name, room.CreatorID, room.IsPinned, 
 			room.TopicTitle, room.TopicDescription, room.TopicURL, 
-			room.TopicSource, room.TopicUpdatedAt, room.ExpiresAt,
+			room.TopicSource, room.TopicUpdatedAt, room.ExpiresAt, room.ProfanityAction,
 		).Scan(
 			&room.ID,
 			&room.CreatedAt,
@@ -65,11 +102,11 @@ func (r *RoomRepository) CreateRoom(ctx context.Context, room *Room) (*Room, err
 	} else {
 		// Regular rooms get default 24-hour expiration
 		query = `
-			INSERT INTO rooms (name, creator_id)
-			VALUES ($1, $2)
+			INSERT INTO rooms (name, creator_id, profanity_action)
+			VALUES ($1, $2, $3)
 			RETURNING id, created_at, expires_at
 		`
-		err = r.db.QueryRowContext(ctx, query, room.Name, room.CreatorID).Scan(
+		err = r.db.QueryRowContext(ctx, query, room.Name, room.CreatorID, room.ProfanityAction).Scan(
 			&room.ID,
 			&room.CreatedAt,
 			&room.ExpiresAt,
@@ -79,14 +116,16 @@ func (r *RoomRepository) CreateRoom(ctx context.Context, room *Room) (*Room, err
 	if err != nil {
 		return nil, fmt.Errorf("insert room: %w", err)
 	}
+	room.Kind = KindPublic
 
 	return room, nil
 }
 
 func (r *RoomRepository) GetRoomByID(ctx context.Context, id uuid.UUID) (*Room, error) {
 	query := `
-		SELECT id, name, creator_id, created_at, expires_at, is_pinned, 
-		       topic_title, topic_description, topic_url, topic_source, topic_updated_at
+		SELECT id, name, kind, creator_id, created_at, expires_at, is_pinned, 
+		       topic_title, topic_description, topic_url, topic_source, topic_updated_at,
+		       profanity_action
 		FROM rooms
 		WHERE id = $1 AND expires_at > NOW()
 	`
@@ -95,6 +134,7 @@ func (r *RoomRepository) GetRoomByID(ctx context.Context, id uuid.UUID) (*Room,
 	err := r.db.QueryRowContext(ctx, query, id).Scan(
 		&room.ID,
 		&room.Name,
+		&room.Kind,
 		&room.CreatorID,
 		&room.CreatedAt,
 		&room.ExpiresAt,
@@ -104,6 +144,7 @@ func (r *RoomRepository) GetRoomByID(ctx context.Context, id uuid.UUID) (*Room,
 		&room.TopicURL,
 		&room.TopicSource,
 		&room.TopicUpdatedAt,
+		&room.ProfanityAction,
 	)
 
 	if err != nil {
@@ -118,10 +159,11 @@ func (r *RoomRepository) GetRoomByID(ctx context.Context, id uuid.UUID) (*Room,
 
 func (r *RoomRepository) GetAllActiveRooms(ctx context.Context) ([]*Room, error) {
 	query := `
-		SELECT id, name, creator_id, created_at, expires_at, is_pinned,
-		       topic_title, topic_description, topic_url, topic_source, topic_updated_at
+		SELECT id, name, kind, creator_id, created_at, expires_at, is_pinned,
+		       topic_title, topic_description, topic_url, topic_source, topic_updated_at,
+		       profanity_action
 		FROM rooms
-		WHERE expires_at > NOW()
+		WHERE expires_at > NOW() AND kind = 'public'
 		ORDER BY is_pinned DESC, created_at DESC
 	`
 
@@ -137,6 +179,7 @@ func (r *RoomRepository) GetAllActiveRooms(ctx context.Context) ([]*Room, error)
 		err := rows.Scan(
 			&room.ID,
 			&room.Name,
+			&room.Kind,
 			&room.CreatorID,
 			&room.CreatedAt,
 			&room.ExpiresAt,
@@ -146,6 +189,7 @@ func (r *RoomRepository) GetAllActiveRooms(ctx context.Context) ([]*Room, error)
 			&room.TopicURL,
 			&room.TopicSource,
 			&room.TopicUpdatedAt,
+			&room.ProfanityAction,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("scan room: %w", err)
@@ -162,7 +206,7 @@ func (r *RoomRepository) GetAllActiveRooms(ctx context.Context) ([]*Room, error)
 
 func (r *RoomRepository) CountActiveRooms(ctx context.Context) (int, error) {
 	var count int
-	query := `SELECT COUNT(*) FROM rooms WHERE expires_at > NOW()`
+	query := `SELECT COUNT(*) FROM rooms WHERE expires_at > NOW() AND kind = 'public'`
 	err := r.db.QueryRowContext(ctx, query).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("count active rooms: %w", err)
@@ -170,32 +214,184 @@ func (r *RoomRepository) CountActiveRooms(ctx context.Context) (int, error) {
 	return count, nil
 }
 
-func (r *RoomRepository) CreateMessage(ctx context.Context, msg *Message) (*Message, error) {
+// CreateMessages stores a batch of messages for one room in a single
+// statement. Sequence numbers are allocated in slice order, and each message
+// is stamped with its ID, sequence number and creation time.
+func (r *RoomRepository) CreateMessages(ctx context.Context, roomID uuid.UUID, msgs []*Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	userIDs := make([]sql.NullString, len(msgs))
+	usernames := make([]string, len(msgs))
+	contents := make([]string, len(msgs))
+	system := make([]bool, len(msgs))
+	bot := make([]bool, len(msgs))
+	replyTo := make([]sql.NullString, len(msgs))
+	clientIDs := make([]sql.NullString, len(msgs))
+	for i, msg := range msgs {
+		if msg.UserID != nil {
+			userIDs[i] = sql.NullString{String: msg.UserID.String(), Valid: true}
+		}
+		if msg.ReplyTo != nil {
+			replyTo[i] = sql.NullString{String: msg.ReplyTo.String(), Valid: true}
+		}
+		if msg.ClientID != nil {
+			clientIDs[i] = sql.NullString{String: *msg.ClientID, Valid: true}
+		}
+		usernames[i] = msg.Username
+		contents[i] = msg.Content
+		system[i] = msg.IsSystem
+		bot[i] = msg.IsBot
+	}
+
 	query := `
-		INSERT INTO messages (room_id, user_id, username, content, is_system)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, created_at
+		WITH next_seq AS (
+			UPDATE rooms SET last_seq = last_seq + $2
+			WHERE id = $1
+			RETURNING last_seq - $2 AS base
+		)
+		INSERT INTO messages (room_id, user_id, username, content, is_system, is_bot, seq, reply_to, client_id)
+		SELECT $1, b.user_id, b.username, b.content, b.is_system, b.is_bot, next_seq.base + b.ord, b.reply_to, b.client_id
+		FROM next_seq, unnest($3::uuid[], $4::text[], $5::text[], $6::bool[], $7::uuid[], $8::text[], $9::bool[])
+		     WITH ORDINALITY AS b(user_id, username, content, is_system, reply_to, client_id, is_bot, ord)
+		ORDER BY b.ord
+		RETURNING id, seq, created_at
 	`
 
-	err := r.db.QueryRowContext(
-		ctx, query,
-		msg.RoomID, msg.UserID, msg.Username, msg.Content, msg.IsSystem,
-	).Scan(&msg.ID, &msg.CreatedAt)
+	rows, err := r.db.QueryContext(ctx, query,
+		roomID, len(msgs),
+		pq.Array(userIDs), pq.Array(usernames), pq.Array(contents), pq.BoolArray(system), pq.Array(replyTo),
+		pq.Array(clientIDs), pq.BoolArray(bot),
+	)
+	if err != nil {
+		return fmt.Errorf("insert messages: %w", err)
+	}
+	defer rows.Close()
+
+	type stored struct {
+		id        uuid.UUID
+		seq       int64
+		createdAt time.Time
+	}
+	var inserted []stored
+	firstSeq := int64(-1)
+	for rows.Next() {
+		var s stored
+		if err := rows.Scan(&s.id, &s.seq, &s.createdAt); err != nil {
+			return fmt.Errorf("scan inserted message: %w", err)
+		}
+		if firstSeq < 0 || s.seq < firstSeq {
+			firstSeq = s.seq
+		}
+		inserted = append(inserted, s)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("insert messages: %w", err)
+	}
+	if len(inserted) == 0 {
+		return errors.New("room not found")
+	}
+
+	// RETURNING order isn't guaranteed, but sequence numbers follow slice order
+	for _, s := range inserted {
+		msg := msgs[s.seq-firstSeq]
+		msg.ID = s.id
+		msg.RoomID = roomID
+		msg.Seq = s.seq
+		msg.CreatedAt = s.createdAt
+	}
+
+	return nil
+}
+
+// ClientKey identifies a send by its author and the author's idempotency key
+type ClientKey struct {
+	UserID   uuid.UUID
+	ClientID string
+}
+
+// GetMessagesByClientKeys returns the stored messages matching any of keys,
+// with their ID, room, author, sequence number, creation time and ClientID set
+func (r *RoomRepository) GetMessagesByClientKeys(ctx context.Context, keys []ClientKey) ([]*Message, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	userIDs := make([]string, 0, len(keys))
+	clientIDs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		userIDs = append(userIDs, k.UserID.String())
+		clientIDs = append(clientIDs, k.ClientID)
+	}
+
+	query := `
+		SELECT m.id, m.room_id, m.user_id, m.seq, m.created_at, m.client_id
+		FROM unnest($1::uuid[], $2::text[]) AS k(user_id, client_id)
+		JOIN messages m ON m.user_id = k.user_id AND m.client_id = k.client_id
+	`
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(userIDs), pq.Array(clientIDs))
+	if err != nil {
+		return nil, fmt.Errorf("query messages by client id: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		var msg Message
+		if err := rows.Scan(&msg.ID, &msg.RoomID, &msg.UserID, &msg.Seq, &msg.CreatedAt, &msg.ClientID); err != nil {
+			return nil, fmt.Errorf("scan message by client id: %w", err)
+		}
+		messages = append(messages, &msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate messages by client id: %w", err)
+	}
+
+	return messages, nil
+}
+
+// GetLiveMessageIDs reports which of ids are non-deleted messages in the room
+func (r *RoomRepository) GetLiveMessageIDs(ctx context.Context, roomID uuid.UUID, ids []uuid.UUID) (map[uuid.UUID]bool, error) {
+	live := make(map[uuid.UUID]bool, len(ids))
+	if len(ids) == 0 {
+		return live, nil
+	}
+
+	strIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		strIDs = append(strIDs, id.String())
+	}
 
+	query := `SELECT id FROM messages WHERE room_id = $1 AND id = ANY($2::uuid[]) AND deleted_at IS NULL`
+	rows, err := r.db.QueryContext(ctx, query, roomID, pq.Array(strIDs))
 	if err != nil {
-		return nil, fmt.Errorf("insert message: %w", err)
+		return nil, fmt.Errorf("query live messages: %w", err)
 	}
+	defer rows.Close()
 
-	return msg, nil
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan message id: %w", err)
+		}
+		live[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate live messages: %w", err)
+	}
+
+	return live, nil
 }
 
 func (r *RoomRepository) GetRoomMessages(ctx context.Context, roomID uuid.UUID, limit int) ([]*Message, error) {
 	query := `
-		SELECT m.id, m.room_id, m.user_id, m.username, m.content, m.is_system, m.created_at
+		SELECT m.id, m.room_id, m.user_id, m.username, m.content, m.is_system, m.is_bot, m.seq, m.created_at, m.edited_at, m.deleted_at,
+		       m.reply_to, (SELECT COUNT(*) FROM messages c WHERE c.reply_to = m.id AND c.deleted_at IS NULL)
 		FROM messages m
 		INNER JOIN rooms r ON m.room_id = r.id
 		WHERE m.room_id = $1 AND r.expires_at > NOW()
-		ORDER BY m.created_at DESC
+		ORDER BY m.seq DESC
 		LIMIT $2
 	`
 
@@ -205,6 +401,344 @@ func (r *RoomRepository) GetRoomMessages(ctx context.Context, roomID uuid.UUID,
 	}
 	defer rows.Close()
 
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reverse the messages to get chronological order
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
+
+// GetRoomMessagesSince returns up to limit messages with a sequence number after since, oldest first
+func (r *RoomRepository) GetRoomMessagesSince(ctx context.Context, roomID uuid.UUID, since int64, limit int) ([]*Message, error) {
+	query := `
+		SELECT m.id, m.room_id, m.user_id, m.username, m.content, m.is_system, m.is_bot, m.seq, m.created_at, m.edited_at, m.deleted_at,
+		       m.reply_to, (SELECT COUNT(*) FROM messages c WHERE c.reply_to = m.id AND c.deleted_at IS NULL)
+		FROM messages m
+		INNER JOIN rooms r ON m.room_id = r.id
+		WHERE m.room_id = $1 AND m.seq > $2 AND r.expires_at > NOW()
+		ORDER BY m.seq ASC
+		LIMIT $3
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, roomID, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query room messages since %d: %w", since, err)
+	}
+	defer rows.Close()
+
+	return scanMessages(rows)
+}
+
+// GetMessageByID returns a message in the given room, or nil if it doesn't exist
+func (r *RoomRepository) GetMessageByID(ctx context.Context, roomID, id uuid.UUID) (*Message, error) {
+	query := `
+		SELECT m.id, m.room_id, m.user_id, m.username, m.content, m.is_system, m.is_bot, m.seq, m.created_at, m.edited_at, m.deleted_at,
+		       m.reply_to, (SELECT COUNT(*) FROM messages c WHERE c.reply_to = m.id AND c.deleted_at IS NULL)
+		FROM messages m
+		WHERE m.room_id = $1 AND m.id = $2
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, roomID, id)
+	if err != nil {
+		return nil, fmt.Errorf("query message by id: %w", err)
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	return messages[0], nil
+}
+
+// GetMessagesBefore returns up to limit messages older than the cursor, newest first.
+// A nil cursor starts from the newest message. Pages follow idx_messages_room_seq;
+// timestamps can't order them since a batch of sends is stored with one created_at.
+func (r *RoomRepository) GetMessagesBefore(ctx context.Context, roomID uuid.UUID, cursor *Message, limit int) ([]*Message, error) {
+	query := `
+		SELECT m.id, m.room_id, m.user_id, m.username, m.content, m.is_system, m.is_bot, m.seq, m.created_at, m.edited_at, m.deleted_at,
+		       m.reply_to, (SELECT COUNT(*) FROM messages c WHERE c.reply_to = m.id AND c.deleted_at IS NULL)
+		FROM messages m
+		INNER JOIN rooms r ON m.room_id = r.id
+		WHERE m.room_id = $1 AND r.expires_at > NOW()
+		ORDER BY m.seq DESC
+		LIMIT $2
+	`
+	args := []any{roomID, limit}
+
+	if cursor != nil {
+		query = `
+			SELECT m.id, m.room_id, m.user_id, m.username, m.content, m.is_system, m.is_bot, m.seq, m.created_at, m.edited_at, m.deleted_at,
+			       m.reply_to, (SELECT COUNT(*) FROM messages c WHERE c.reply_to = m.id AND c.deleted_at IS NULL)
+			FROM messages m
+			INNER JOIN rooms r ON m.room_id = r.id
+			WHERE m.room_id = $1 AND r.expires_at > NOW()
+			  AND m.seq < $3
+			ORDER BY m.seq DESC
+			LIMIT $2
+		`
+		args = append(args, cursor.Seq)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query messages before cursor: %w", err)
+	}
+	defer rows.Close()
+
+	return scanMessages(rows)
+}
+
+// GetMessagesAfter returns up to limit messages newer than the cursor, oldest first
+func (r *RoomRepository) GetMessagesAfter(ctx context.Context, roomID uuid.UUID, cursor *Message, limit int) ([]*Message, error) {
+	query := `
+		SELECT m.id, m.room_id, m.user_id, m.username, m.content, m.is_system, m.is_bot, m.seq, m.created_at, m.edited_at, m.deleted_at,
+		       m.reply_to, (SELECT COUNT(*) FROM messages c WHERE c.reply_to = m.id AND c.deleted_at IS NULL)
+		FROM messages m
+		INNER JOIN rooms r ON m.room_id = r.id
+		WHERE m.room_id = $1 AND r.expires_at > NOW()
+		  AND m.seq > $3
+		ORDER BY m.seq ASC
+		LIMIT $2
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, roomID, limit, cursor.Seq)
+	if err != nil {
+		return nil, fmt.Errorf("query messages after cursor: %w", err)
+	}
+	defer rows.Close()
+
+	return scanMessages(rows)
+}
+
+// GetThreadReplies returns up to limit direct replies to a message, oldest first
+func (r *RoomRepository) GetThreadReplies(ctx context.Context, roomID, parentID uuid.UUID, limit int) ([]*Message, error) {
+	query := `
+		SELECT m.id, m.room_id, m.user_id, m.username, m.content, m.is_system, m.is_bot, m.seq, m.created_at, m.edited_at, m.deleted_at,
+		       m.reply_to, (SELECT COUNT(*) FROM messages c WHERE c.reply_to = m.id AND c.deleted_at IS NULL)
+		FROM messages m
+		INNER JOIN rooms r ON m.room_id = r.id
+		WHERE m.room_id = $1 AND m.reply_to = $2 AND r.expires_at > NOW()
+		ORDER BY m.seq ASC
+		LIMIT $3
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, roomID, parentID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query thread replies: %w", err)
+	}
+	defer rows.Close()
+
+	return scanMessages(rows)
+}
+
+// EditMessage replaces a message's content, keeping the old version in
+// message_edits. It returns nil if the message doesn't exist or was deleted.
+func (r *RoomRepository) EditMessage(ctx context.Context, roomID, id uuid.UUID, content string) (*Message, error) {
+	query := `
+		WITH prev AS (
+			SELECT id, content FROM messages
+			WHERE room_id = $1 AND id = $2 AND deleted_at IS NULL
+			FOR UPDATE
+		), history AS (
+			INSERT INTO message_edits (message_id, content)
+			SELECT id, content FROM prev
+		)
+		UPDATE messages m SET content = $3, edited_at = NOW()
+		FROM prev
+		WHERE m.id = prev.id
+		RETURNING m.id, m.room_id, m.user_id, m.username, m.content, m.is_system, m.is_bot, m.seq, m.created_at, m.edited_at, m.deleted_at,
+		       m.reply_to, (SELECT COUNT(*) FROM messages c WHERE c.reply_to = m.id AND c.deleted_at IS NULL)
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, roomID, id, content)
+	if err != nil {
+		return nil, fmt.Errorf("edit message: %w", err)
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	return messages[0], nil
+}
+
+// DeleteMessage turns a message into a tombstone. Its content, edit history and
+// reactions are removed so only the ID, author and timestamps remain. It returns nil if
+// the message doesn't exist or was already deleted.
+func (r *RoomRepository) DeleteMessage(ctx context.Context, roomID, id uuid.UUID) (*Message, error) {
+	query := `
+		WITH history AS (
+			DELETE FROM message_edits e USING messages t
+			WHERE e.message_id = t.id AND t.room_id = $1 AND t.id = $2 AND t.deleted_at IS NULL
+		), reactions AS (
+			DELETE FROM message_reactions mr USING messages t
+			WHERE mr.message_id = t.id AND t.room_id = $1 AND t.id = $2 AND t.deleted_at IS NULL
+		)
+		UPDATE messages m SET content = '', deleted_at = NOW()
+		WHERE m.room_id = $1 AND m.id = $2 AND m.deleted_at IS NULL
+		RETURNING m.id, m.room_id, m.user_id, m.username, m.content, m.is_system, m.is_bot, m.seq, m.created_at, m.edited_at, m.deleted_at,
+		       m.reply_to, (SELECT COUNT(*) FROM messages c WHERE c.reply_to = m.id AND c.deleted_at IS NULL)
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, roomID, id)
+	if err != nil {
+		return nil, fmt.Errorf("delete message: %w", err)
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	return messages[0], nil
+}
+
+// GetMessageEdits returns the previous versions of a message, oldest first
+func (r *RoomRepository) GetMessageEdits(ctx context.Context, messageID uuid.UUID) ([]*MessageEdit, error) {
+	query := `
+		SELECT id, message_id, content, edited_at
+		FROM message_edits
+		WHERE message_id = $1
+		ORDER BY edited_at ASC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("query message edits: %w", err)
+	}
+	defer rows.Close()
+
+	var edits []*MessageEdit
+	for rows.Next() {
+		var e MessageEdit
+		if err := rows.Scan(&e.ID, &e.MessageID, &e.Content, &e.EditedAt); err != nil {
+			return nil, fmt.Errorf("scan message edit: %w", err)
+		}
+		edits = append(edits, &e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate message edits: %w", err)
+	}
+
+	return edits, nil
+}
+
+// SearchFilter narrows a full-text search over active rooms
+type SearchFilter struct {
+	Query    string
+	RoomID   *uuid.UUID
+	UserID   *uuid.UUID
+	Username string
+	From     *time.Time
+	To       *time.Time
+	Limit    int
+	Offset   int
+
+	// ViewerID lets the search include the viewer's direct rooms
+	ViewerID *uuid.UUID
+}
+
+// SearchResult is a matching message with its rank and a highlighted snippet
+type SearchResult struct {
+	Message
+	RoomName string  `json:"room_name"`
+	Rank     float64 `json:"rank"`
+	Snippet  string  `json:"snippet"`
+}
+
+// SearchMessages runs a ranked full-text search over messages in rooms that haven't expired.
+// Snippets are built from HTML-escaped content, so the only markup in them is <mark>.
+func (r *RoomRepository) SearchMessages(ctx context.Context, f SearchFilter) ([]*SearchResult, error) {
+	query := `
+		WITH q AS (SELECT websearch_to_tsquery('english', $1) AS query)
+		SELECT m.id, m.room_id, m.user_id, m.username, m.content, m.is_system, m.is_bot, m.seq, m.created_at, m.edited_at, m.deleted_at,
+		       m.reply_to, (SELECT COUNT(*) FROM messages c WHERE c.reply_to = m.id AND c.deleted_at IS NULL),
+		       r.name,
+		       ts_rank(m.search_vector, q.query) AS rank,
+		       ts_headline('english',
+		           replace(replace(replace(m.content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+		           q.query,
+		           'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5')
+		FROM messages m
+		INNER JOIN rooms r ON m.room_id = r.id
+		CROSS JOIN q
+		WHERE m.search_vector @@ q.query
+		  AND r.expires_at > NOW()
+		  AND (r.kind = 'public' OR EXISTS (
+		      SELECT 1 FROM room_members rm WHERE rm.room_id = r.id AND rm.user_id = $9
+		  ))
+		  AND m.is_system = FALSE
+		  AND m.deleted_at IS NULL
+		  AND ($2::uuid IS NULL OR m.room_id = $2)
+		  AND ($3::uuid IS NULL OR m.user_id = $3)
+		  AND ($4 = '' OR LOWER(m.username) = LOWER($4))
+		  AND ($5::timestamptz IS NULL OR m.created_at >= $5)
+		  AND ($6::timestamptz IS NULL OR m.created_at < $6)
+		ORDER BY rank DESC, m.created_at DESC
+		LIMIT $7 OFFSET $8
+	`
+
+	rows, err := r.db.QueryContext(ctx, query,
+		f.Query, f.RoomID, f.UserID, f.Username, f.From, f.To, f.Limit, f.Offset, f.ViewerID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("search messages: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*SearchResult
+	for rows.Next() {
+		var res SearchResult
+		err := rows.Scan(
+			&res.ID,
+			&res.RoomID,
+			&res.UserID,
+			&res.Username,
+			&res.Content,
+			&res.IsSystem,
+			&res.IsBot,
+			&res.Seq,
+			&res.CreatedAt,
+			&res.EditedAt,
+			&res.DeletedAt,
+			&res.ReplyTo,
+			&res.ReplyCount,
+			&res.RoomName,
+			&res.Rank,
+			&res.Snippet,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scan search result: %w", err)
+		}
+		results = append(results, &res)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate search results: %w", err)
+	}
+
+	return results, nil
+}
+
+func scanMessages(rows *sql.Rows) ([]*Message, error) {
 	var messages []*Message
 	for rows.Next() {
 		var msg Message
@@ -215,7 +749,13 @@ func (r *RoomRepository) GetRoomMessages(ctx context.Context, roomID uuid.UUID,
 			&msg.Username,
 			&msg.Content,
 			&msg.IsSystem,
+			&msg.IsBot,
+			&msg.Seq,
 			&msg.CreatedAt,
+			&msg.EditedAt,
+			&msg.DeletedAt,
+			&msg.ReplyTo,
+			&msg.ReplyCount,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("scan message: %w", err)
@@ -227,33 +767,37 @@ func (r *RoomRepository) GetRoomMessages(ctx context.Context, roomID uuid.UUID,
 		return nil, fmt.Errorf("iterate messages: %w", err)
 	}
 
-	// Reverse the messages to get chronological order
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
-	}
-
 	return messages, nil
 }
 
-func (r *RoomRepository) DeleteExpiredRooms(ctx context.Context) (int, error) {
-	query := `DELETE FROM rooms WHERE expires_at <= NOW()`
-	
-	result, err := r.db.ExecContext(ctx, query)
+func (r *RoomRepository) DeleteExpiredRooms(ctx context.Context) ([]uuid.UUID, error) {
+	query := `DELETE FROM rooms WHERE expires_at <= NOW() AND kind = 'public' RETURNING id`
+
+	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
-		return 0, fmt.Errorf("delete expired rooms: %w", err)
+		return nil, fmt.Errorf("delete expired rooms: %w", err)
 	}
+	defer rows.Close()
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return 0, fmt.Errorf("get rows affected: %w", err)
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan deleted room id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate deleted rooms: %w", err)
 	}
 
-	return int(rowsAffected), nil
+	return ids, nil
 }
 
 func (r *RoomRepository) HasActiveRoom(ctx context.Context, userID uuid.UUID) (bool, error) {
 	var count int
-	query := `SELECT COUNT(*) FROM rooms WHERE creator_id = $1 AND expires_at > NOW()`
+	query := `SELECT COUNT(*) FROM rooms WHERE creator_id = $1 AND expires_at > NOW() AND kind = 'public'`
 	err := r.db.QueryRowContext(ctx, query, userID).Scan(&count)
 	if err != nil {
 		return false, fmt.Errorf("check active room: %w", err)
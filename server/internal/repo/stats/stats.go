@@ -3,10 +3,12 @@ package stats
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type UserStats struct {
@@ -281,6 +283,34 @@ func (r *StatsRepository) IncrementMessageCount(ctx context.Context, userID uuid
 	return nil
 }
 
+// AddMessageCounts adds a batch of per-user message counts in one statement,
+// creating stats rows as needed. Users that no longer exist are skipped.
+func (r *StatsRepository) AddMessageCounts(ctx context.Context, deltas map[uuid.UUID]int) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	userIDs := make([]string, 0, len(deltas))
+	counts := make([]int64, 0, len(deltas))
+	for userID, n := range deltas {
+		userIDs = append(userIDs, userID.String())
+		counts = append(counts, int64(n))
+	}
+
+	query := `
+		INSERT INTO user_stats (user_id, total_messages)
+		SELECT d.user_id, d.n
+		FROM unnest($1::uuid[], $2::int[]) AS d(user_id, n)
+		JOIN users u ON u.id = d.user_id
+		ON CONFLICT (user_id) DO UPDATE
+		SET total_messages = user_stats.total_messages + EXCLUDED.total_messages, updated_at = NOW()`
+
+	if _, err := r.db.ExecContext(ctx, query, pq.Array(userIDs), pq.Array(counts)); err != nil {
+		return fmt.Errorf("add message counts: %w", err)
+	}
+	return nil
+}
+
 // CheckAndAwardAchievements checks if user has earned new achievements and awards them
 func (r *StatsRepository) CheckAndAwardAchievements(ctx context.Context, userID uuid.UUID) ([]Achievement, error) {
 	// Get user's current stats
@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+// APIToken is a long-lived credential for a user or one of their bots. Only
+// a hash of the token is stored.
+type APIToken struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+
+	// Username of the account the token acts as, filled in when listing
+	Username string `json:"username"`
+}
+
+// CreateBot adds a bot account owned by ownerID
+func (r *UserRepository) CreateBot(ctx context.Context, ownerID uuid.UUID, username string) (*User, error) {
+	query := `
+		INSERT INTO users (username, account_type, owner_id)
+		VALUES ($1, $2, $3)
+		RETURNING id, username, account_type, owner_id, created_at, updated_at
+	`
+
+	var user User
+	err := r.db.QueryRowContext(ctx, query, username, AccountBot, ownerID).Scan(
+		&user.ID,
+		&user.Username,
+		&user.AccountType,
+		&user.OwnerID,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, errors.New("username already exists")
+		}
+		return nil, fmt.Errorf("insert bot: %w", err)
+	}
+
+	return &user, nil
+}
+
+// GetBotsByOwner returns the bots a user owns, oldest first
+func (r *UserRepository) GetBotsByOwner(ctx context.Context, ownerID uuid.UUID) ([]*User, error) {
+	query := `
+		SELECT id, username, account_type, owner_id, created_at, updated_at
+		FROM users
+		WHERE owner_id = $1 AND account_type = $2
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, ownerID, AccountBot)
+	if err != nil {
+		return nil, fmt.Errorf("query bots: %w", err)
+	}
+	defer rows.Close()
+
+	var bots []*User
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.AccountType,
+			&user.OwnerID,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scan bot: %w", err)
+		}
+		bots = append(bots, &user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate bots: %w", err)
+	}
+
+	return bots, nil
+}
+
+// CreateAPIToken stores a new token by its hash
+func (r *UserRepository) CreateAPIToken(ctx context.Context, token *APIToken, hash string) (*APIToken, error) {
+	query := `
+		INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRowContext(ctx, query,
+		token.UserID, token.Name, hash, token.Prefix, pq.Array(token.Scopes), token.ExpiresAt,
+	).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("insert api token: %w", err)
+	}
+
+	return token, nil
+}
+
+// GetAPITokensByOwner returns the tokens of a user and of the bots they own,
+// newest first. Revoked tokens are included so they can be told apart.
+func (r *UserRepository) GetAPITokensByOwner(ctx context.Context, ownerID uuid.UUID) ([]*APIToken, error) {
+	query := `
+		SELECT t.id, t.user_id, t.name, t.prefix, t.scopes, t.created_at, t.expires_at, t.last_used_at, t.revoked_at, u.username
+		FROM api_tokens t
+		INNER JOIN users u ON u.id = t.user_id
+		WHERE u.id = $1 OR u.owner_id = $1
+		ORDER BY t.created_at DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("query api tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []*APIToken
+	for rows.Next() {
+		var t APIToken
+		err := rows.Scan(
+			&t.ID,
+			&t.UserID,
+			&t.Name,
+			&t.Prefix,
+			pq.Array(&t.Scopes),
+			&t.CreatedAt,
+			&t.ExpiresAt,
+			&t.LastUsedAt,
+			&t.RevokedAt,
+			&t.Username,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scan api token: %w", err)
+		}
+		tokens = append(tokens, &t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate api tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// RevokeAPIToken revokes a token belonging to ownerID or one of their bots.
+// It reports false if there is no such live token.
+func (r *UserRepository) RevokeAPIToken(ctx context.Context, ownerID, tokenID uuid.UUID) (bool, error) {
+	query := `
+		UPDATE api_tokens t SET revoked_at = NOW()
+		FROM users u
+		WHERE t.id = $2 AND t.revoked_at IS NULL
+		  AND u.id = t.user_id AND (u.id = $1 OR u.owner_id = $1)
+	`
+
+	result, err := r.db.ExecContext(ctx, query, ownerID, tokenID)
+	if err != nil {
+		return false, fmt.Errorf("revoke api token: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get rows affected: %w", err)
+	}
+	return n > 0, nil
+}
+
+// UseAPIToken looks up a live token by its hash and records that it was used.
+// It returns nil if the token is unknown, expired or revoked.
+func (r *UserRepository) UseAPIToken(ctx context.Context, hash string) (*APIToken, error) {
+	query := `
+		UPDATE api_tokens SET last_used_at = NOW()
+		WHERE token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+		RETURNING id, user_id, name, prefix, scopes, created_at, expires_at, last_used_at
+	`
+
+	var t APIToken
+	err := r.db.QueryRowContext(ctx, query, hash).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		&t.Prefix,
+		pq.Array(&t.Scopes),
+		&t.CreatedAt,
+		&t.ExpiresAt,
+		&t.LastUsedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("use api token: %w", err)
+	}
+
+	return &t, nil
+}
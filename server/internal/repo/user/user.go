@@ -5,12 +5,19 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 )
 
+// Account types. Bots are owned by a user and only sign in with API tokens.
+const (
+	AccountUser = "user"
+	AccountBot  = "bot"
+)
+
 type User struct {
 	ID           uuid.UUID `json:"id"`
 	Username     string    `json:"username"`
@@ -18,6 +25,14 @@ type User struct {
 	PasswordHash *string   `json:"-"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
+
+	AccountType string     `json:"account_type"`
+	OwnerID     *uuid.UUID `json:"owner_id,omitempty"`
+}
+
+// IsBot reports whether the account is a bot
+func (u *User) IsBot() bool {
+	return u.AccountType == AccountBot
 }
 
 type UserRepository struct {
@@ -30,7 +45,7 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 
 func (r *UserRepository) GetUserByID(ctx context.Context, id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, username, email, password_hash, created_at, updated_at
+		SELECT id, username, COALESCE(email, ''), password_hash, account_type, owner_id, created_at, updated_at
 		FROM users
 		WHERE id = $1
 	`
@@ -41,6 +56,8 @@ func (r *UserRepository) GetUserByID(ctx context.Context, id uuid.UUID) (*User,
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash,
+		&user.AccountType,
+		&user.OwnerID,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -55,9 +72,58 @@ func (r *UserRepository) GetUserByID(ctx context.Context, id uuid.UUID) (*User,
 	return &user, nil
 }
 
+// GetUsersByUsernames looks up users by username, ignoring case
+func (r *UserRepository) GetUsersByUsernames(ctx context.Context, usernames []string) ([]*User, error) {
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+
+	lowered := make([]string, 0, len(usernames))
+	for _, name := range usernames {
+		lowered = append(lowered, strings.ToLower(name))
+	}
+
+	query := `
+		SELECT id, username, COALESCE(email, ''), password_hash, account_type, owner_id, created_at, updated_at
+		FROM users
+		WHERE LOWER(username) = ANY($1)
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(lowered))
+	if err != nil {
+		return nil, fmt.Errorf("query users by username: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.Email,
+			&user.PasswordHash,
+			&user.AccountType,
+			&user.OwnerID,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scan user: %w", err)
+		}
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate users: %w", err)
+	}
+
+	return users, nil
+}
+
 func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, username, email, password_hash, created_at, updated_at
+		SELECT id, username, COALESCE(email, ''), password_hash, account_type, owner_id, created_at, updated_at
 		FROM users
 		WHERE email = $1
 	`
@@ -68,6 +134,8 @@ func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*Use
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash,
+		&user.AccountType,
+		&user.OwnerID,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -86,12 +154,12 @@ func (r *UserRepository) CreateUser(ctx context.Context, user *User) (*User, err
 	query := `
 		INSERT INTO users (username, email, password_hash)
 		VALUES ($1, $2, $3)
-		RETURNING id, created_at, updated_at
+		RETURNING id, account_type, created_at, updated_at
 	`
 
 	err := r.db.QueryRowContext(
 		ctx, query,
-		user.Username, user.Email, user.PasswordHash).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+		user.Username, user.Email, user.PasswordHash).Scan(&user.ID, &user.AccountType, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
 		if pgErr, ok := err.(*pq.Error); ok && pgErr.Code == "23505" {
@@ -135,7 +203,7 @@ func (r *UserRepository) UpdateUsername(ctx context.Context, id uuid.UUID, usern
 		UPDATE users 
 		SET username = $1, updated_at = NOW()
 		WHERE id = $2
-		RETURNING id, username, email, password_hash, created_at, updated_at
+		RETURNING id, username, COALESCE(email, ''), password_hash, account_type, owner_id, created_at, updated_at
 	`
 
 	var user User
@@ -144,6 +212,8 @@ func (r *UserRepository) UpdateUsername(ctx context.Context, id uuid.UUID, usern
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash,
+		&user.AccountType,
+		&user.OwnerID,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -160,3 +230,40 @@ func (r *UserRepository) UpdateUsername(ctx context.Context, id uuid.UUID, usern
 
 	return &user, nil
 }
+
+// BlockUser stops blocked from contacting blocker. Blocking twice is a no-op.
+func (r *UserRepository) BlockUser(ctx context.Context, blockerID, blockedID uuid.UUID) error {
+	query := `
+		INSERT INTO user_blocks (blocker_id, blocked_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := r.db.ExecContext(ctx, query, blockerID, blockedID); err != nil {
+		return fmt.Errorf("block user: %w", err)
+	}
+	return nil
+}
+
+// UnblockUser lifts a block, if there is one
+func (r *UserRepository) UnblockUser(ctx context.Context, blockerID, blockedID uuid.UUID) error {
+	query := `DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2`
+	if _, err := r.db.ExecContext(ctx, query, blockerID, blockedID); err != nil {
+		return fmt.Errorf("unblock user: %w", err)
+	}
+	return nil
+}
+
+// IsBlockedEitherWay reports whether either user has blocked the other
+func (r *UserRepository) IsBlockedEitherWay(ctx context.Context, a, b uuid.UUID) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM user_blocks
+			WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+		)
+	`
+	var blocked bool
+	if err := r.db.QueryRowContext(ctx, query, a, b).Scan(&blocked); err != nil {
+		return false, fmt.Errorf("check block: %w", err)
+	}
+	return blocked, nil
+}
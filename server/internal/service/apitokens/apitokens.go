@@ -0,0 +1,302 @@
+package apitokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Melkeydev/yappr/internal/api/model"
+	repo "github.com/Melkeydev/yappr/internal/repo/user"
+)
+
+const (
+	// TokenPrefix marks a string as a yappr API token
+	TokenPrefix = "yap_"
+	// Characters of a token kept in the clear so users can tell tokens apart
+	displayPrefixLength = 12
+
+	MaxBotUsernameLength = 32
+	MaxTokenNameLength   = 64
+	MaxTokenLifetimeDays = 365
+)
+
+type APITokenService struct {
+	userRepo *repo.UserRepository
+	timeout  time.Duration
+}
+
+func NewAPITokenService(userRepo *repo.UserRepository) *APITokenService {
+	return &APITokenService{
+		userRepo: userRepo,
+		timeout:  time.Duration(5) * time.Second,
+	}
+}
+
+// CreateBot adds a bot account owned by the caller
+func (s *APITokenService) CreateBot(ctx context.Context, ownerID uuid.UUID, req model.CreateBotReq) (*model.BotRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	username := strings.TrimSpace(req.Username)
+	if username == "" || len(username) > MaxBotUsernameLength {
+		return nil, ErrInvalidBotName
+	}
+	if model.IsReservedUsername(username) {
+		return nil, ErrReservedBotName
+	}
+
+	owner, err := s.userRepo.GetUserByID(ctx, ownerID)
+	if err != nil {
+		log.Printf("APITokenService.CreateBot - Failed to load owner %s: %v", ownerID, err)
+		return nil, err
+	}
+	if owner == nil || owner.IsBot() {
+		return nil, ErrBotOwner
+	}
+
+	bot, err := s.userRepo.CreateBot(ctx, ownerID, username)
+	if err != nil {
+		if err.Error() == "username already exists" {
+			return nil, ErrBotNameTaken
+		}
+		log.Printf("APITokenService.CreateBot - Insert failed for owner %s: %v", ownerID, err)
+		return nil, err
+	}
+
+	log.Printf("APITokenService.CreateBot - User %s created bot %s (%s)", ownerID, bot.ID, bot.Username)
+	return toBotRes(bot), nil
+}
+
+// ListBots returns the caller's bots
+func (s *APITokenService) ListBots(ctx context.Context, ownerID uuid.UUID) ([]*model.BotRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	bots, err := s.userRepo.GetBotsByOwner(ctx, ownerID)
+	if err != nil {
+		log.Printf("APITokenService.ListBots - Query failed for owner %s: %v", ownerID, err)
+		return nil, err
+	}
+
+	res := make([]*model.BotRes, 0, len(bots))
+	for _, bot := range bots {
+		res = append(res, toBotRes(bot))
+	}
+	return res, nil
+}
+
+// CreateToken issues a token for the caller or one of their bots. The token
+// itself is only returned here; just its hash is stored.
+func (s *APITokenService) CreateToken(ctx context.Context, ownerID uuid.UUID, req model.CreateAPITokenReq) (*model.CreatedAPITokenRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > MaxTokenNameLength {
+		return nil, ErrInvalidTokenName
+	}
+
+	var scopes []string
+	for _, scope := range req.Scopes {
+		if !model.IsAPIScope(scope) {
+			return nil, &APITokenError{Code: "INVALID_SCOPE", Message: "unknown scope " + scope}
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, ErrNoScopes
+	}
+
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > MaxTokenLifetimeDays {
+		return nil, ErrInvalidExpiry
+	}
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	account, err := s.tokenAccount(ctx, ownerID, req.BotID)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := newToken()
+	if err != nil {
+		log.Printf("APITokenService.CreateToken - Failed to generate token: %v", err)
+		return nil, err
+	}
+
+	token, err := s.userRepo.CreateAPIToken(ctx, &repo.APIToken{
+		UserID:    account.ID,
+		Name:      name,
+		Prefix:    raw[:displayPrefixLength],
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		Username:  account.Username,
+	}, hashToken(raw))
+	if err != nil {
+		log.Printf("APITokenService.CreateToken - Insert failed for user %s: %v", account.ID, err)
+		return nil, err
+	}
+
+	log.Printf("APITokenService.CreateToken - User %s created token %s for %s with scopes %v", ownerID, token.ID, account.ID, scopes)
+	return &model.CreatedAPITokenRes{APITokenRes: ToAPITokenRes(token), Token: raw}, nil
+}
+
+// tokenAccount returns the account a new token acts as: the caller, or their bot
+func (s *APITokenService) tokenAccount(ctx context.Context, ownerID uuid.UUID, botID string) (*repo.User, error) {
+	accountID := ownerID
+	if botID != "" {
+		id, err := uuid.Parse(botID)
+		if err != nil {
+			return nil, ErrBotNotFound
+		}
+		accountID = id
+	}
+
+	account, err := s.userRepo.GetUserByID(ctx, accountID)
+	if err != nil {
+		log.Printf("APITokenService.tokenAccount - Failed to load user %s: %v", accountID, err)
+		return nil, err
+	}
+
+	if botID == "" {
+		if account == nil {
+			return nil, ErrBotOwner
+		}
+		return account, nil
+	}
+	if account == nil || !account.IsBot() || account.OwnerID == nil || *account.OwnerID != ownerID {
+		return nil, ErrBotNotFound
+	}
+	return account, nil
+}
+
+// ListTokens returns the tokens of the caller and their bots, revoked ones included
+func (s *APITokenService) ListTokens(ctx context.Context, ownerID uuid.UUID) ([]model.APITokenRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	tokens, err := s.userRepo.GetAPITokensByOwner(ctx, ownerID)
+	if err != nil {
+		log.Printf("APITokenService.ListTokens - Query failed for owner %s: %v", ownerID, err)
+		return nil, err
+	}
+
+	res := make([]model.APITokenRes, 0, len(tokens))
+	for _, t := range tokens {
+		res = append(res, ToAPITokenRes(t))
+	}
+	return res, nil
+}
+
+// RevokeToken revokes one of the caller's or their bots' tokens
+func (s *APITokenService) RevokeToken(ctx context.Context, ownerID, tokenID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	revoked, err := s.userRepo.RevokeAPIToken(ctx, ownerID, tokenID)
+	if err != nil {
+		log.Printf("APITokenService.RevokeToken - Update failed for token %s: %v", tokenID, err)
+		return err
+	}
+	if !revoked {
+		return ErrTokenNotFound
+	}
+
+	log.Printf("APITokenService.RevokeToken - User %s revoked token %s", ownerID, tokenID)
+	return nil
+}
+
+// VerifyToken resolves a presented token to the account it acts as and its
+// scopes. Unknown, expired and revoked tokens get ErrInvalidToken.
+func (s *APITokenService) VerifyToken(ctx context.Context, raw string) (string, []string, error) {
+	if !strings.HasPrefix(raw, TokenPrefix) {
+		return "", nil, ErrInvalidToken
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	token, err := s.userRepo.UseAPIToken(ctx, hashToken(raw))
+	if err != nil {
+		return "", nil, err
+	}
+	if token == nil {
+		return "", nil, ErrInvalidToken
+	}
+	return token.UserID.String(), token.Scopes, nil
+}
+
+// newToken returns a random token. 32 bytes make guessing hopeless, so a fast
+// hash is enough to store it.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func toBotRes(u *repo.User) *model.BotRes {
+	return &model.BotRes{
+		ID:        u.ID.String(),
+		Username:  u.Username,
+		CreatedAt: u.CreatedAt,
+	}
+}
+
+// ToAPITokenRes converts a stored token to its API form
+func ToAPITokenRes(t *repo.APIToken) model.APITokenRes {
+	return model.APITokenRes{
+		ID:         t.ID.String(),
+		UserID:     t.UserID.String(),
+		Username:   t.Username,
+		Name:       t.Name,
+		Prefix:     t.Prefix,
+		Scopes:     t.Scopes,
+		CreatedAt:  t.CreatedAt,
+		ExpiresAt:  t.ExpiresAt,
+		LastUsedAt: t.LastUsedAt,
+		RevokedAt:  t.RevokedAt,
+	}
+}
+
+// Custom errors
+var (
+	ErrInvalidBotName   = &APITokenError{Code: "INVALID_NAME", Message: "bot username must be 1 to 32 characters"}
+	ErrReservedBotName  = &APITokenError{Code: "INVALID_NAME", Message: "usernames starting with \"" + model.GuestUsernamePrefix + "\" are reserved"}
+	ErrBotNameTaken     = &APITokenError{Code: "NAME_TAKEN", Message: "username already exists"}
+	ErrBotOwner         = &APITokenError{Code: "FORBIDDEN", Message: "only signed-in users can manage bots and tokens"}
+	ErrBotNotFound      = &APITokenError{Code: "BOT_NOT_FOUND", Message: "bot not found"}
+	ErrInvalidTokenName = &APITokenError{Code: "INVALID_NAME", Message: "token name must be 1 to 64 characters"}
+	ErrNoScopes         = &APITokenError{Code: "INVALID_SCOPE", Message: "at least one scope is required"}
+	ErrInvalidExpiry    = &APITokenError{Code: "INVALID_EXPIRY", Message: "expires_in_days must be between 0 and 365"}
+	ErrTokenNotFound    = &APITokenError{Code: "TOKEN_NOT_FOUND", Message: "token not found or already revoked"}
+	ErrInvalidToken     = &APITokenError{Code: "INVALID_TOKEN", Message: "invalid or revoked API token"}
+)
+
+type APITokenError struct {
+	Code    string
+	Message string
+}
+
+func (e *APITokenError) Error() string {
+	return e.Message
+}
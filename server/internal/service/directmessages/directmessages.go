@@ -0,0 +1,163 @@
+package directmessages
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Melkeydev/yappr/internal/api/model"
+	roomRepo "github.com/Melkeydev/yappr/internal/repo/room"
+	userRepo "github.com/Melkeydev/yappr/internal/repo/user"
+	"github.com/Melkeydev/yappr/internal/ws"
+)
+
+type DirectMessageService struct {
+	roomRepo *roomRepo.RoomRepository
+	userRepo *userRepo.UserRepository
+	wsCore   *ws.Core
+	timeout  time.Duration
+}
+
+func NewDirectMessageService(roomRepo *roomRepo.RoomRepository, userRepo *userRepo.UserRepository, wsCore *ws.Core) *DirectMessageService {
+	return &DirectMessageService{
+		roomRepo: roomRepo,
+		userRepo: userRepo,
+		wsCore:   wsCore,
+		timeout:  time.Duration(5) * time.Second,
+	}
+}
+
+// OpenConversation returns the direct room between the user and the named
+// user, creating it on first contact
+func (s *DirectMessageService) OpenConversation(ctx context.Context, userID uuid.UUID, username string) (*model.DirectRoomRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, ErrUserNotFound
+	}
+
+	users, err := s.userRepo.GetUsersByUsernames(ctx, []string{username})
+	if err != nil {
+		log.Printf("DirectMessageService.OpenConversation - Failed to look up %q: %v", username, err)
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrUserNotFound
+	}
+	other := users[0]
+
+	if other.ID == userID {
+		return nil, ErrMessageSelf
+	}
+
+	blocked, err := s.userRepo.IsBlockedEitherWay(ctx, userID, other.ID)
+	if err != nil {
+		log.Printf("DirectMessageService.OpenConversation - Failed to check blocks: %v", err)
+		return nil, err
+	}
+	if blocked {
+		return nil, ErrBlocked
+	}
+
+	room, err := s.roomRepo.GetOrCreateDirectRoom(ctx, userID, other.ID)
+	if err != nil {
+		log.Printf("DirectMessageService.OpenConversation - Failed to open room: %v", err)
+		return nil, err
+	}
+
+	return &model.DirectRoomRes{
+		ID:            room.ID.String(),
+		OtherUserID:   other.ID.String(),
+		OtherUsername: other.Username,
+		CreatedAt:     room.CreatedAt,
+	}, nil
+}
+
+// ListConversations returns the user's direct rooms
+func (s *DirectMessageService) ListConversations(ctx context.Context, userID uuid.UUID) ([]model.DirectRoomRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	rooms, err := s.roomRepo.ListDirectRooms(ctx, userID)
+	if err != nil {
+		log.Printf("DirectMessageService.ListConversations - Query failed for user %s: %v", userID, err)
+		return nil, err
+	}
+
+	res := make([]model.DirectRoomRes, 0, len(rooms))
+	for _, r := range rooms {
+		res = append(res, model.DirectRoomRes{
+			ID:            r.ID.String(),
+			OtherUserID:   r.OtherUserID.String(),
+			OtherUsername: r.OtherUsername,
+			CreatedAt:     r.CreatedAt,
+		})
+	}
+	return res, nil
+}
+
+// Block stops the other user from contacting this one and disconnects both
+// from their conversation, if they have one
+func (s *DirectMessageService) Block(ctx context.Context, userID, otherID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if userID == otherID {
+		return ErrMessageSelf
+	}
+
+	other, err := s.userRepo.GetUserByID(ctx, otherID)
+	if err != nil {
+		return err
+	}
+	if other == nil {
+		return ErrUserNotFound
+	}
+
+	if err := s.userRepo.BlockUser(ctx, userID, otherID); err != nil {
+		log.Printf("DirectMessageService.Block - Failed to block %s: %v", otherID, err)
+		return err
+	}
+
+	room, err := s.roomRepo.GetDirectRoom(ctx, userID, otherID)
+	if err != nil {
+		return err
+	}
+	if room != nil {
+		s.wsCore.CloseRoom(room.ID.String(), "conversation blocked")
+	}
+	return nil
+}
+
+// Unblock lifts a block the user placed
+func (s *DirectMessageService) Unblock(ctx context.Context, userID, otherID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if err := s.userRepo.UnblockUser(ctx, userID, otherID); err != nil {
+		log.Printf("DirectMessageService.Unblock - Failed to unblock %s: %v", otherID, err)
+		return err
+	}
+	return nil
+}
+
+// Custom errors
+var (
+	ErrUserNotFound = &DirectMessageError{Code: "USER_NOT_FOUND", Message: "user not found"}
+	ErrMessageSelf  = &DirectMessageError{Code: "INVALID_TARGET", Message: "you can't message or block yourself"}
+	ErrBlocked      = &DirectMessageError{Code: "BLOCKED", Message: "this conversation is blocked"}
+)
+
+type DirectMessageError struct {
+	Code    string
+	Message string
+}
+
+func (e *DirectMessageError) Error() string {
+	return e.Message
+}
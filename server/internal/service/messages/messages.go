@@ -0,0 +1,699 @@
+package messages
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/Melkeydev/yappr/internal/api/model"
+	"github.com/Melkeydev/yappr/internal/filter"
+	roomRepo "github.com/Melkeydev/yappr/internal/repo/room"
+	userRepo "github.com/Melkeydev/yappr/internal/repo/user"
+	"github.com/Melkeydev/yappr/internal/ws"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 100
+
+	DefaultSearchSize = 20
+	MaxSearchSize     = 50
+	maxSearchQueryLen = 200
+
+	// Authors can edit or delete their messages for this long after sending
+	EditWindow = 15 * time.Minute
+
+	// Most different emoji a single message can collect
+	MaxReactionsPerMessage = 20
+	maxEmojiBytes          = 32
+)
+
+type MessageService struct {
+	roomRepo *roomRepo.RoomRepository
+	userRepo *userRepo.UserRepository
+	wsCore   *ws.Core
+	timeout  time.Duration
+}
+
+func NewMessageService(roomRepo *roomRepo.RoomRepository, userRepo *userRepo.UserRepository, wsCore *ws.Core) *MessageService {
+	return &MessageService{
+		roomRepo: roomRepo,
+		userRepo: userRepo,
+		wsCore:   wsCore,
+		timeout:  time.Duration(5) * time.Second,
+	}
+}
+
+// PageQuery selects a page of a room's history. At most one of Before and After is set;
+// with neither, the newest messages are returned.
+type PageQuery struct {
+	RoomID uuid.UUID
+	Before *uuid.UUID
+	After  *uuid.UUID
+	Limit  int
+
+	// ViewerID is the caller, if signed in, for private rooms
+	ViewerID *uuid.UUID
+}
+
+// ListMessages returns a page of messages in chronological order. Prev is the cursor
+// to pass as before= for older messages and Next the cursor to pass as after= for
+// newer ones; each is nil when there is nothing further in that direction.
+func (s *MessageService) ListMessages(ctx context.Context, q PageQuery) (*model.MessagePageRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if q.Before != nil && q.After != nil {
+		return nil, ErrConflictingCursors
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	room, err := s.roomRepo.GetRoomByID(ctx, q.RoomID)
+	if err != nil {
+		log.Printf("MessageService.ListMessages - Failed to load room %s: %v", q.RoomID, err)
+		return nil, err
+	}
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	if err := s.checkAccess(ctx, room, q.ViewerID); err != nil {
+		return nil, err
+	}
+
+	var cursor *roomRepo.Message
+	if cursorID := q.Before; cursorID != nil || q.After != nil {
+		if cursorID == nil {
+			cursorID = q.After
+		}
+		cursor, err = s.roomRepo.GetMessageByID(ctx, q.RoomID, *cursorID)
+		if err != nil {
+			return nil, err
+		}
+		if cursor == nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	// Fetch one extra row to learn whether another page exists
+	var rows []*roomRepo.Message
+	if q.After != nil {
+		rows, err = s.roomRepo.GetMessagesAfter(ctx, q.RoomID, cursor, limit+1)
+	} else {
+		rows, err = s.roomRepo.GetMessagesBefore(ctx, q.RoomID, cursor, limit+1)
+	}
+	if err != nil {
+		log.Printf("MessageService.ListMessages - Query failed for room %s: %v", q.RoomID, err)
+		return nil, err
+	}
+
+	hasMore := len(rows) > limit
+	if hasMore {
+		rows = rows[:limit]
+	}
+
+	// Backward pages come back newest first
+	if q.After == nil {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	if err := s.roomRepo.LoadReactions(ctx, rows); err != nil {
+		log.Printf("MessageService.ListMessages - Failed to load reactions for room %s: %v", q.RoomID, err)
+		return nil, err
+	}
+
+	page := &model.MessagePageRes{Messages: make([]model.MessageRes, 0, len(rows))}
+	for _, m := range rows {
+		page.Messages = append(page.Messages, ToMessageRes(m))
+	}
+
+	if len(rows) > 0 {
+		oldest := rows[0].ID.String()
+		newest := rows[len(rows)-1].ID.String()
+
+		switch {
+		case q.After != nil:
+			page.Prev = &oldest
+			if hasMore {
+				page.Next = &newest
+			}
+		case q.Before != nil:
+			page.Next = &newest
+			if hasMore {
+				page.Prev = &oldest
+			}
+		default:
+			if hasMore {
+				page.Prev = &oldest
+			}
+		}
+	}
+
+	return page, nil
+}
+
+// SearchQuery describes a full-text search request
+type SearchQuery struct {
+	Query    string
+	RoomID   *uuid.UUID
+	UserID   *uuid.UUID
+	Username string
+	From     *time.Time
+	To       *time.Time
+	Limit    int
+	Offset   int
+	ViewerID *uuid.UUID
+}
+
+// Search finds messages matching the query across active rooms, best matches first
+func (s *MessageService) Search(ctx context.Context, q SearchQuery) ([]model.SearchResultRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	q.Query = strings.TrimSpace(q.Query)
+	if q.Query == "" {
+		return nil, ErrEmptyQuery
+	}
+	if len(q.Query) > maxSearchQueryLen {
+		return nil, ErrQueryTooLong
+	}
+	if q.From != nil && q.To != nil && !q.From.Before(*q.To) {
+		return nil, ErrInvalidTimeRange
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultSearchSize
+	}
+	if limit > MaxSearchSize {
+		limit = MaxSearchSize
+	}
+	offset := q.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	results, err := s.roomRepo.SearchMessages(ctx, roomRepo.SearchFilter{
+		Query:    q.Query,
+		RoomID:   q.RoomID,
+		UserID:   q.UserID,
+		Username: strings.TrimSpace(q.Username),
+		From:     q.From,
+		To:       q.To,
+		Limit:    limit,
+		Offset:   offset,
+		ViewerID: q.ViewerID,
+	})
+	if err != nil {
+		log.Printf("MessageService.Search - Query failed: %v", err)
+		return nil, err
+	}
+
+	res := make([]model.SearchResultRes, 0, len(results))
+	for _, r := range results {
+		res = append(res, model.SearchResultRes{
+			MessageRes: ToMessageRes(&r.Message),
+			RoomName:   r.RoomName,
+			Rank:       r.Rank,
+			Snippet:    r.Snippet,
+		})
+	}
+
+	return res, nil
+}
+
+// GetThread returns a message and its direct replies, oldest first. A deleted
+// parent is still returned as a tombstone so its replies keep their context.
+func (s *MessageService) GetThread(ctx context.Context, roomID, messageID uuid.UUID, viewerID *uuid.UUID, limit int) (*model.ThreadRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	room, err := s.roomRepo.GetRoomByID(ctx, roomID)
+	if err != nil {
+		log.Printf("MessageService.GetThread - Failed to load room %s: %v", roomID, err)
+		return nil, err
+	}
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	if err := s.checkAccess(ctx, room, viewerID); err != nil {
+		return nil, err
+	}
+
+	parent, err := s.roomRepo.GetMessageByID(ctx, roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		return nil, ErrMessageNotFound
+	}
+
+	replies, err := s.roomRepo.GetThreadReplies(ctx, roomID, messageID, limit+1)
+	if err != nil {
+		log.Printf("MessageService.GetThread - Query failed for message %s: %v", messageID, err)
+		return nil, err
+	}
+
+	hasMore := len(replies) > limit
+	if hasMore {
+		replies = replies[:limit]
+	}
+
+	if err := s.roomRepo.LoadReactions(ctx, append([]*roomRepo.Message{parent}, replies...)); err != nil {
+		log.Printf("MessageService.GetThread - Failed to load reactions for message %s: %v", messageID, err)
+		return nil, err
+	}
+
+	thread := &model.ThreadRes{Parent: ToMessageRes(parent), HasMore: hasMore}
+
+	thread.Replies = make([]model.MessageRes, 0, len(replies))
+	for _, m := range replies {
+		thread.Replies = append(thread.Replies, ToMessageRes(m))
+	}
+
+	return thread, nil
+}
+
+// EditMessage replaces the content of one of the user's own messages and
+// broadcasts the change to the room
+func (s *MessageService) EditMessage(ctx context.Context, roomID, messageID, userID uuid.UUID, content string) (*model.MessageRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	// Edits follow the same content rules as messages sent over the socket
+	content, protoErr := ws.SanitizeContent(content)
+	if protoErr != nil {
+		return nil, &MessageError{Code: "INVALID_CONTENT", Message: protoErr.Message}
+	}
+
+	room, err := s.roomRepo.GetRoomByID(ctx, roomID)
+	if err != nil {
+		log.Printf("MessageService.EditMessage - Failed to load room %s: %v", roomID, err)
+		return nil, err
+	}
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	if err := s.checkWriteAccess(ctx, room, userID); err != nil {
+		return nil, err
+	}
+
+	msg, err := s.loadMessage(ctx, roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.IsSystem || msg.UserID == nil || *msg.UserID != userID {
+		return nil, ErrNotMessageAuthor
+	}
+	if time.Since(msg.CreatedAt) > EditWindow {
+		return nil, ErrEditWindowClosed
+	}
+
+	// Edits get the same profanity treatment as the room's live messages
+	action := s.wsCore.ProfanityActionFor(room.ProfanityAction)
+	content, matches, rejected := s.wsCore.ModerateContent(action, content)
+	if rejected {
+		return nil, ErrInappropriateContent
+	}
+
+	edited, err := s.roomRepo.EditMessage(ctx, roomID, messageID, content)
+	if err != nil {
+		log.Printf("MessageService.EditMessage - Failed to edit message %s: %v", messageID, err)
+		return nil, err
+	}
+	if edited == nil {
+		// Deleted between the check and the update
+		return nil, ErrMessageDeleted
+	}
+
+	if action == filter.ActionFlag && len(matches) > 0 {
+		if err := s.roomRepo.FlagMessage(ctx, messageID, roomRepo.FlagReasonProfanity, ws.MatchedTerms(matches)); err != nil {
+			log.Printf("MessageService.EditMessage - Failed to flag message %s: %v", messageID, err)
+		}
+	}
+
+	s.wsCore.PublishUpdate(edited)
+
+	res := ToMessageRes(edited)
+	return &res, nil
+}
+
+// DeleteMessage tombstones a message. Authors can delete their own messages
+// within EditWindow; the room's creator can delete any message at any time.
+func (s *MessageService) DeleteMessage(ctx context.Context, roomID, messageID, userID uuid.UUID) (*model.MessageRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	room, err := s.roomRepo.GetRoomByID(ctx, roomID)
+	if err != nil {
+		log.Printf("MessageService.DeleteMessage - Failed to load room %s: %v", roomID, err)
+		return nil, err
+	}
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	if err := s.checkWriteAccess(ctx, room, userID); err != nil {
+		return nil, err
+	}
+
+	msg, err := s.loadMessage(ctx, roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	isOwner := room.CreatorID != nil && *room.CreatorID == userID
+	isAuthor := !msg.IsSystem && msg.UserID != nil && *msg.UserID == userID
+	switch {
+	case isOwner:
+	case !isAuthor:
+		return nil, ErrNotMessageAuthor
+	case time.Since(msg.CreatedAt) > EditWindow:
+		return nil, ErrEditWindowClosed
+	}
+
+	deleted, err := s.roomRepo.DeleteMessage(ctx, roomID, messageID)
+	if err != nil {
+		log.Printf("MessageService.DeleteMessage - Failed to delete message %s: %v", messageID, err)
+		return nil, err
+	}
+	if deleted == nil {
+		return nil, ErrMessageDeleted
+	}
+
+	s.wsCore.PublishUpdate(deleted)
+
+	res := ToMessageRes(deleted)
+	return &res, nil
+}
+
+// AddReaction adds the user's emoji reaction to a message and pushes the new count to the room
+func (s *MessageService) AddReaction(ctx context.Context, roomID, messageID, userID uuid.UUID, emoji string) (*model.ReactionRes, error) {
+	return s.react(ctx, roomID, messageID, userID, emoji, true)
+}
+
+// RemoveReaction takes back the user's emoji reaction and pushes the new count to the room
+func (s *MessageService) RemoveReaction(ctx context.Context, roomID, messageID, userID uuid.UUID, emoji string) (*model.ReactionRes, error) {
+	return s.react(ctx, roomID, messageID, userID, emoji, false)
+}
+
+func (s *MessageService) react(ctx context.Context, roomID, messageID, userID uuid.UUID, emoji string, add bool) (*model.ReactionRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if !validEmoji(emoji) {
+		return nil, ErrInvalidEmoji
+	}
+
+	room, err := s.roomRepo.GetRoomByID(ctx, roomID)
+	if err != nil {
+		log.Printf("MessageService.react - Failed to load room %s: %v", roomID, err)
+		return nil, err
+	}
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	if err := s.checkAccess(ctx, room, &userID); err != nil {
+		return nil, err
+	}
+
+	msg, err := s.loadMessage(ctx, roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.IsSystem {
+		return nil, ErrCannotReact
+	}
+
+	if add {
+		ok, err := s.roomRepo.AddReaction(ctx, messageID, userID, emoji, MaxReactionsPerMessage)
+		if err != nil {
+			log.Printf("MessageService.react - Failed to add reaction to %s: %v", messageID, err)
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrTooManyReactions
+		}
+	} else {
+		if err := s.roomRepo.RemoveReaction(ctx, messageID, userID, emoji); err != nil {
+			log.Printf("MessageService.react - Failed to remove reaction from %s: %v", messageID, err)
+			return nil, err
+		}
+	}
+
+	count, err := s.roomRepo.CountReaction(ctx, messageID, emoji)
+	if err != nil {
+		return nil, err
+	}
+
+	s.wsCore.PublishReaction(roomID.String(), ws.ReactionPayload{
+		MessageID: messageID.String(),
+		Emoji:     emoji,
+		UserID:    userID.String(),
+		Added:     add,
+		Count:     count,
+	})
+
+	return &model.ReactionRes{Emoji: emoji, Count: count}, nil
+}
+
+// validEmoji accepts short strings of symbols, which covers emoji with skin
+// tones, variation selectors and ZWJ sequences, but rejects words and whitespace
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiBytes || !utf8.ValidString(emoji) {
+		return false
+	}
+
+	hasSymbol := false
+	for _, r := range emoji {
+		switch {
+		case unicode.IsSpace(r), unicode.IsControl(r), unicode.IsLetter(r):
+			return false
+		case r >= 0x2000:
+			hasSymbol = true
+		}
+	}
+	return hasSymbol
+}
+
+// ListEdits returns the previous versions of a message, oldest first
+func (s *MessageService) ListEdits(ctx context.Context, roomID, messageID uuid.UUID, viewerID *uuid.UUID) ([]model.MessageEditRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	room, err := s.roomRepo.GetRoomByID(ctx, roomID)
+	if err != nil {
+		log.Printf("MessageService.ListEdits - Failed to load room %s: %v", roomID, err)
+		return nil, err
+	}
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	if err := s.checkAccess(ctx, room, viewerID); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.loadMessage(ctx, roomID, messageID); err != nil {
+		return nil, err
+	}
+
+	edits, err := s.roomRepo.GetMessageEdits(ctx, messageID)
+	if err != nil {
+		log.Printf("MessageService.ListEdits - Query failed for message %s: %v", messageID, err)
+		return nil, err
+	}
+
+	res := make([]model.MessageEditRes, 0, len(edits))
+	for _, e := range edits {
+		res = append(res, model.MessageEditRes{Content: e.Content, EditedAt: e.EditedAt})
+	}
+	return res, nil
+}
+
+// MarkRead moves the user's read cursor in a room forward to seq and returns
+// what's left unread. Their other connections are told about the new cursor.
+func (s *MessageService) MarkRead(ctx context.Context, roomID, userID uuid.UUID, seq int64) (*model.ReadStateRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if seq < 0 {
+		return nil, ErrInvalidReadSeq
+	}
+
+	room, err := s.roomRepo.GetRoomByID(ctx, roomID)
+	if err != nil {
+		log.Printf("MessageService.MarkRead - Failed to load room %s: %v", roomID, err)
+		return nil, err
+	}
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	if err := s.checkAccess(ctx, room, &userID); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.wsCore.MarkRead(ctx, userID.String(), roomID.String(), seq); err != nil {
+		log.Printf("MessageService.MarkRead - Failed to mark room %s read for %s: %v", roomID, userID, err)
+		return nil, err
+	}
+
+	states, err := s.roomRepo.GetReadStates(ctx, userID, []uuid.UUID{roomID})
+	if err != nil {
+		log.Printf("MessageService.MarkRead - Failed to load read state for room %s: %v", roomID, err)
+		return nil, err
+	}
+
+	state := states[roomID]
+	return &model.ReadStateRes{
+		RoomID:      roomID.String(),
+		LastReadSeq: state.LastReadSeq,
+		UnreadCount: state.UnreadCount,
+	}, nil
+}
+
+// checkAccess hides private rooms from anyone who isn't a member
+func (s *MessageService) checkAccess(ctx context.Context, room *roomRepo.Room, viewerID *uuid.UUID) error {
+	allowed, err := s.roomRepo.CanAccessRoom(ctx, room, viewerID)
+	if err != nil {
+		log.Printf("MessageService.checkAccess - Failed to check access to room %s: %v", room.ID, err)
+		return err
+	}
+	if !allowed {
+		return ErrRoomNotFound
+	}
+	return nil
+}
+
+// checkWriteAccess is checkAccess for changing a room's messages. In a direct
+// room it also refuses both members once either has blocked the other, like
+// joining the room does.
+func (s *MessageService) checkWriteAccess(ctx context.Context, room *roomRepo.Room, userID uuid.UUID) error {
+	if err := s.checkAccess(ctx, room, &userID); err != nil {
+		return err
+	}
+	if room.Kind != roomRepo.KindDirect {
+		return nil
+	}
+
+	members, err := s.roomRepo.GetRoomMemberIDs(ctx, room.ID)
+	if err != nil {
+		log.Printf("MessageService.checkWriteAccess - Failed to load members of room %s: %v", room.ID, err)
+		return err
+	}
+	for _, m := range members {
+		if m == userID {
+			continue
+		}
+		blocked, err := s.userRepo.IsBlockedEitherWay(ctx, userID, m)
+		if err != nil {
+			log.Printf("MessageService.checkWriteAccess - Failed to check blocks in room %s: %v", room.ID, err)
+			return err
+		}
+		if blocked {
+			return ErrConversationBlocked
+		}
+	}
+	return nil
+}
+
+// loadMessage fetches a live, non-deleted message in an active room
+func (s *MessageService) loadMessage(ctx context.Context, roomID, messageID uuid.UUID) (*roomRepo.Message, error) {
+	msg, err := s.roomRepo.GetMessageByID(ctx, roomID, messageID)
+	if err != nil {
+		log.Printf("MessageService.loadMessage - Failed to load message %s: %v", messageID, err)
+		return nil, err
+	}
+	if msg == nil {
+		return nil, ErrMessageNotFound
+	}
+	if msg.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
+	return msg, nil
+}
+
+// ToMessageRes converts a stored message to its API representation
+func ToMessageRes(m *roomRepo.Message) model.MessageRes {
+	var userID *string
+	if m.UserID != nil {
+		id := m.UserID.String()
+		userID = &id
+	}
+
+	var replyTo *string
+	if m.ReplyTo != nil {
+		id := m.ReplyTo.String()
+		replyTo = &id
+	}
+
+	var reactions []model.ReactionRes
+	for _, rc := range m.Reactions {
+		reactions = append(reactions, model.ReactionRes{Emoji: rc.Emoji, Count: rc.Count})
+	}
+
+	return model.MessageRes{
+		ID:         m.ID.String(),
+		Seq:        m.Seq,
+		RoomID:     m.RoomID.String(),
+		UserID:     userID,
+		Username:   m.Username,
+		Content:    m.Content,
+		System:     m.IsSystem,
+		Bot:        m.IsBot,
+		CreatedAt:  m.CreatedAt,
+		EditedAt:   m.EditedAt,
+		DeletedAt:  m.DeletedAt,
+		ReplyTo:    replyTo,
+		ReplyCount: m.ReplyCount,
+		Reactions:  reactions,
+	}
+}
+
+// Custom errors
+var (
+	ErrRoomNotFound       = &MessageError{Code: "ROOM_NOT_FOUND", Message: "room not found or expired"}
+	ErrInvalidCursor      = &MessageError{Code: "INVALID_CURSOR", Message: "cursor does not refer to a message in this room"}
+	ErrConflictingCursors = &MessageError{Code: "CONFLICTING_CURSORS", Message: "only one of before and after may be set"}
+	ErrEmptyQuery         = &MessageError{Code: "INVALID_QUERY", Message: "search query is required"}
+	ErrQueryTooLong       = &MessageError{Code: "INVALID_QUERY", Message: "search query is too long"}
+	ErrInvalidTimeRange   = &MessageError{Code: "INVALID_QUERY", Message: "from must be before to"}
+	ErrMessageNotFound    = &MessageError{Code: "MESSAGE_NOT_FOUND", Message: "message not found"}
+	ErrMessageDeleted     = &MessageError{Code: "MESSAGE_DELETED", Message: "message has been deleted"}
+	ErrNotMessageAuthor   = &MessageError{Code: "FORBIDDEN", Message: "you can only change your own messages"}
+	ErrEditWindowClosed   = &MessageError{Code: "FORBIDDEN", Message: "messages can only be changed shortly after sending"}
+	ErrInvalidEmoji       = &MessageError{Code: "INVALID_CONTENT", Message: "reaction must be an emoji"}
+	ErrCannotReact        = &MessageError{Code: "FORBIDDEN", Message: "system messages can't be reacted to"}
+	ErrTooManyReactions   = &MessageError{Code: "TOO_MANY_REACTIONS", Message: "this message has too many different reactions"}
+	ErrInvalidReadSeq     = &MessageError{Code: "INVALID_CURSOR", Message: "seq must not be negative"}
+
+	ErrConversationBlocked  = &MessageError{Code: "FORBIDDEN", Message: "this conversation is blocked"}
+	ErrInappropriateContent = &MessageError{Code: "INVALID_CONTENT", Message: "message contains inappropriate language"}
+)
+
+type MessageError struct {
+	Code    string
+	Message string
+}
+
+func (e *MessageError) Error() string {
+	return e.Message
+}
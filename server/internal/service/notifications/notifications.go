@@ -0,0 +1,107 @@
+package notifications
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Melkeydev/yappr/internal/api/model"
+	notificationRepo "github.com/Melkeydev/yappr/internal/repo/notification"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+type NotificationService struct {
+	notificationRepo *notificationRepo.NotificationRepository
+	timeout          time.Duration
+}
+
+func NewNotificationService(notificationRepo *notificationRepo.NotificationRepository) *NotificationService {
+	return &NotificationService{
+		notificationRepo: notificationRepo,
+		timeout:          time.Duration(5) * time.Second,
+	}
+}
+
+// ListNotifications returns a page of the user's notifications, newest first,
+// with their total unread count. Next is the before= cursor for the next page.
+func (s *NotificationService) ListNotifications(ctx context.Context, userID uuid.UUID, unreadOnly bool, before *time.Time, limit int) (*model.NotificationListRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	rows, err := s.notificationRepo.ListNotifications(ctx, userID, unreadOnly, before, limit+1)
+	if err != nil {
+		log.Printf("NotificationService.ListNotifications - Query failed for user %s: %v", userID, err)
+		return nil, err
+	}
+
+	unread, err := s.notificationRepo.CountUnread(ctx, userID)
+	if err != nil {
+		log.Printf("NotificationService.ListNotifications - Failed to count unread for user %s: %v", userID, err)
+		return nil, err
+	}
+
+	hasMore := len(rows) > limit
+	if hasMore {
+		rows = rows[:limit]
+	}
+
+	res := &model.NotificationListRes{
+		Notifications: make([]model.NotificationRes, 0, len(rows)),
+		Unread:        unread,
+	}
+	for _, n := range rows {
+		res.Notifications = append(res.Notifications, ToNotificationRes(n))
+	}
+	if hasMore && len(rows) > 0 {
+		next := rows[len(rows)-1].CreatedAt.Format(time.RFC3339Nano)
+		res.Next = &next
+	}
+
+	return res, nil
+}
+
+// MarkRead marks notifications read. With no IDs, every unread notification is marked.
+func (s *NotificationService) MarkRead(ctx context.Context, userID uuid.UUID, ids []uuid.UUID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	updated, err := s.notificationRepo.MarkRead(ctx, userID, ids)
+	if err != nil {
+		log.Printf("NotificationService.MarkRead - Update failed for user %s: %v", userID, err)
+		return 0, err
+	}
+	return updated, nil
+}
+
+// ToNotificationRes converts a stored notification to its API form
+func ToNotificationRes(n *notificationRepo.Notification) model.NotificationRes {
+	res := model.NotificationRes{
+		ID:            n.ID.String(),
+		Kind:          n.Kind,
+		RoomID:        n.RoomID.String(),
+		RoomName:      n.RoomName,
+		ActorUsername: n.ActorUsername,
+		Excerpt:       n.Excerpt,
+		Link:          "/chat/" + n.RoomID.String(),
+		Read:          n.ReadAt != nil,
+		CreatedAt:     n.CreatedAt,
+	}
+	if n.MessageID != nil {
+		id := n.MessageID.String()
+		res.MessageID = &id
+	}
+	return res
+}
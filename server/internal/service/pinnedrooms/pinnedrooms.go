@@ -72,17 +72,8 @@ func (s *PinnedRoomsService) RefreshPinnedRooms(ctx context.Context) error {
 			continue
 		}
 
-		// Add to WebSocket core's in-memory map
-		s.wsCore.Rooms[createdRoom.ID.String()] = &ws.Room{
-			ID:               createdRoom.ID.String(),
-			Name:             createdRoom.Name,
-			Clients:          make(map[string]*ws.Client),
-			IsPinned:         createdRoom.IsPinned,
-			TopicTitle:       createdRoom.TopicTitle,
-			TopicDescription: createdRoom.TopicDescription,
-			TopicURL:         createdRoom.TopicURL,
-			TopicSource:      createdRoom.TopicSource,
-		}
+		// Bring the room online in the WebSocket core
+		s.wsCore.GetOrCreateRoom(ws.NewRoomInfo(createdRoom))
 
 		log.Printf("Created pinned room: %s with topic: %s", createdRoom.Name, topic.Title)
 	}
@@ -43,6 +43,11 @@ func (s *UserService) CreateUser(ctx context.Context, req model.RequestCreateUse
 		return nil, fmt.Errorf("username, email, and password are required")
 	}
 
+	if model.IsReservedUsername(req.Username) {
+		log.Printf("UserService.CreateUser - Validation failed: reserved username prefix")
+		return nil, fmt.Errorf("usernames starting with %q are reserved", model.GuestUsernamePrefix)
+	}
+
 	if len(req.Password) < 6 {
 		log.Printf("UserService.CreateUser - Validation failed: password too short")
 		return nil, fmt.Errorf("password must be at least 6 characters")
@@ -0,0 +1,103 @@
+package ws
+
+import (
+	"sync"
+	"time"
+)
+
+// How long a stored client ID is remembered in memory. Older resends are
+// still caught by the key stored with the message.
+const dedupeWindow = 10 * time.Minute
+
+// ackTarget is a sender waiting to hear that its send was stored: a
+// connection, or an HTTP request blocked on reply
+type ackTarget struct {
+	client *Client
+	envID  string
+	reply  chan<- sendResult
+}
+
+// sentMessage is what an ack for an already stored send needs
+type sentMessage struct {
+	ID  string
+	Seq int64
+	at  time.Time
+}
+
+// recentSends remembers recently stored client IDs per user
+type recentSends struct {
+	mu      sync.Mutex
+	entries map[string]sentMessage
+}
+
+func newRecentSends() *recentSends {
+	return &recentSends{entries: make(map[string]sentMessage)}
+}
+
+// sendKey scopes a client ID to the user (or guest) that sent it
+func sendKey(userID, clientID string) string {
+	return userID + "\x00" + clientID
+}
+
+func (s *recentSends) get(key string) (sentMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sent, ok := s.entries[key]
+	if !ok || time.Since(sent.at) > dedupeWindow {
+		return sentMessage{}, false
+	}
+	return sent, true
+}
+
+func (s *recentSends) remember(key string, m *Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = sentMessage{ID: m.ID, Seq: m.Seq, at: time.Now()}
+}
+
+// prune forgets client IDs older than the window
+func (s *recentSends) prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, sent := range s.entries {
+		if time.Since(sent.at) > dedupeWindow {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// ack tells a sender its message was stored
+func (r *Room) ack(t ackTarget, clientID, messageID string, seq int64, duplicate bool) {
+	ack := AckPayload{
+		ClientID:  clientID,
+		MessageID: messageID,
+		Seq:       seq,
+		Duplicate: duplicate,
+	}
+	if t.reply != nil {
+		t.reply <- sendResult{ack: &ack}
+		return
+	}
+	if _, ok := r.clients[t.client]; !ok {
+		return
+	}
+
+	env := NewEnvelope(EventAck, ack)
+	env.ID = t.envID
+	r.deliver(t.client, env)
+}
+
+// reject tells a sender why its message wasn't sent
+func (r *Room) reject(t ackTarget, code, message string) {
+	if t.reply != nil {
+		t.reply <- sendResult{err: &ProtocolError{Code: code, Message: message}}
+		return
+	}
+	if _, ok := r.clients[t.client]; !ok {
+		return
+	}
+	r.deliver(t.client, NewErrorEnvelope(t.envID, code, message))
+}
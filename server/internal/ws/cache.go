@@ -0,0 +1,351 @@
+package ws
+
+import (
+	"container/list"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/Melkeydev/yappr/util"
+)
+
+const (
+	// Messages kept per room unless WS_HISTORY_CACHE_MESSAGES says otherwise
+	defaultHistoryCacheMessages = 200
+	// Memory shared by every room's buffer unless WS_HISTORY_CACHE_MB says otherwise
+	defaultHistoryCacheMB = 64
+
+	// Rough per-message cost on top of its strings
+	messageOverhead = 160
+)
+
+// historyCache keeps the latest messages of each live room in memory so joins
+// can be replayed without a database round trip. Each room holds at most
+// perRoom messages and all rooms share a byte budget; the least recently used
+// rooms are dropped once it is exceeded.
+type historyCache struct {
+	mu      sync.Mutex
+	rooms   map[string]*roomHistory
+	lru     *list.List // most recently used at the front
+	used    int
+	budget  int
+	perRoom int
+}
+
+// roomHistory is one room's buffer, ordered by sequence number
+type roomHistory struct {
+	roomID string
+	msgs   []*Message
+	bytes  int
+	elem   *list.Element
+
+	// Every stored message after floor should be in msgs. It is -1 until the
+	// buffer has been filled from the database, and nothing is served before then.
+	floor int64
+}
+
+func newHistoryCache() *historyCache {
+	perRoom := defaultHistoryCacheMessages
+	if v, err := strconv.Atoi(util.GetEnv("WS_HISTORY_CACHE_MESSAGES", "")); err == nil && v > 0 {
+		perRoom = v
+	}
+	budgetMB := defaultHistoryCacheMB
+	if v, err := strconv.Atoi(util.GetEnv("WS_HISTORY_CACHE_MB", "")); err == nil && v > 0 {
+		budgetMB = v
+	}
+
+	return &historyCache{
+		rooms:   make(map[string]*roomHistory),
+		lru:     list.New(),
+		budget:  budgetMB << 20,
+		perRoom: perRoom,
+	}
+}
+
+// latest returns the room's last limit messages. It reports false when the
+// buffer can't vouch for them and the database has to be asked instead.
+func (h *historyCache) latest(roomID string, limit int) ([]*Message, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rh, ok := h.rooms[roomID]
+	if !ok || rh.floor < 0 {
+		return nil, false
+	}
+	h.lru.MoveToFront(rh.elem)
+
+	start := len(rh.msgs) - limit
+	if start < 0 {
+		// Fewer messages than asked for is only the full answer if the buffer
+		// goes back to the room's first message
+		if rh.floor != 0 {
+			return nil, false
+		}
+		start = 0
+	}
+	return rh.contiguous(start, len(rh.msgs))
+}
+
+// since returns up to limit messages after seq, like loadHistorySince
+func (h *historyCache) since(roomID string, seq int64, limit int) ([]*Message, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rh, ok := h.rooms[roomID]
+	if !ok || rh.floor < 0 || seq < rh.floor {
+		return nil, false
+	}
+	h.lru.MoveToFront(rh.elem)
+
+	start := sort.Search(len(rh.msgs), func(i int) bool { return rh.msgs[i].Seq > seq })
+	if start < len(rh.msgs) && rh.msgs[start].Seq != seq+1 {
+		return nil, false
+	}
+	return rh.contiguous(start, min(len(rh.msgs), start+limit))
+}
+
+// contiguous copies msgs[start:end] if their sequence numbers have no gaps
+func (rh *roomHistory) contiguous(start, end int) ([]*Message, bool) {
+	for i := start + 1; i < end; i++ {
+		if rh.msgs[i].Seq != rh.msgs[i-1].Seq+1 {
+			return nil, false
+		}
+	}
+	if start == 0 && end > 0 && rh.msgs[0].Seq != rh.floor+1 {
+		return nil, false
+	}
+
+	out := make([]*Message, end-start)
+	copy(out, rh.msgs[start:end])
+	return out, true
+}
+
+// fill merges messages read from the database, oldest first, into the room's
+// buffer. floor is the sequence number they start after: every stored message
+// after it is in msgs or newer than all of them.
+func (h *historyCache) fill(roomID string, msgs []*Message, floor int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rh := h.room(roomID)
+
+	var last int64 = floor
+	if len(msgs) > 0 {
+		last = msgs[len(msgs)-1].Seq
+	}
+
+	// Cached copies already carry any updates that arrived while reading
+	cached := make(map[int64]*Message, len(rh.msgs))
+	merged := make([]*Message, 0, len(msgs)+len(rh.msgs))
+	for _, m := range rh.msgs {
+		if m.Seq > last {
+			continue
+		}
+		cached[m.Seq] = m
+	}
+	for _, m := range msgs {
+		if c, ok := cached[m.Seq]; ok {
+			m = c
+		}
+		merged = append(merged, m)
+	}
+	for _, m := range rh.msgs {
+		if m.Seq > last {
+			merged = append(merged, m)
+		}
+	}
+
+	rh.msgs = merged
+	rh.floor = floor
+	h.trim(rh)
+	h.recount(rh)
+}
+
+// reserve creates the room's buffer ahead of filling it, so messages stored
+// while the database is being read are kept
+func (h *historyCache) reserve(roomID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.room(roomID)
+}
+
+// append adds a stored message, keeping the buffer in sequence order. Rooms
+// without a buffer are skipped; nobody has joined them since it was dropped.
+func (h *historyCache) append(roomID string, m *Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rh, ok := h.rooms[roomID]
+	if !ok {
+		return
+	}
+	h.lru.MoveToFront(rh.elem)
+
+	i := sort.Search(len(rh.msgs), func(i int) bool { return rh.msgs[i].Seq >= m.Seq })
+	switch {
+	case i == len(rh.msgs):
+		rh.msgs = append(rh.msgs, m)
+	case rh.msgs[i].Seq == m.Seq:
+		// Already have it
+		return
+	default:
+		rh.msgs = append(rh.msgs, nil)
+		copy(rh.msgs[i+1:], rh.msgs[i:])
+		rh.msgs[i] = m
+	}
+
+	if m.ReplyTo != "" {
+		rh.patch(m.ReplyTo, func(parent *Message) { parent.ReplyCount++ })
+	}
+
+	h.trim(rh)
+	h.recount(rh)
+}
+
+// update replaces an edited or deleted message. Reactions and reply counts
+// aren't part of the update, so the cached ones are kept.
+func (h *historyCache) update(roomID string, m *Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rh, ok := h.rooms[roomID]
+	if !ok {
+		return
+	}
+
+	deleted := false
+	rh.patch(m.ID, func(cur *Message) {
+		deleted = cur.DeletedAt == "" && m.DeletedAt != ""
+		updated := *m
+		updated.ReplyCount = cur.ReplyCount
+		updated.Reactions = cur.Reactions
+		if m.DeletedAt != "" {
+			updated.Reactions = nil
+		}
+		*cur = updated
+	})
+	if deleted && m.ReplyTo != "" {
+		rh.patch(m.ReplyTo, func(parent *Message) {
+			if parent.ReplyCount > 0 {
+				parent.ReplyCount--
+			}
+		})
+	}
+	h.recount(rh)
+}
+
+// react applies a reaction change to a cached message
+func (h *historyCache) react(roomID string, p ReactionPayload) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rh, ok := h.rooms[roomID]
+	if !ok {
+		return
+	}
+
+	rh.patch(p.MessageID, func(m *Message) {
+		reactions := make([]Reaction, 0, len(m.Reactions)+1)
+		found := false
+		for _, r := range m.Reactions {
+			if r.Emoji == p.Emoji {
+				found = true
+				r.Count = p.Count
+			}
+			if r.Count > 0 {
+				reactions = append(reactions, r)
+			}
+		}
+		if !found && p.Count > 0 {
+			reactions = append(reactions, Reaction{Emoji: p.Emoji, Count: p.Count})
+		}
+		m.Reactions = reactions
+	})
+	h.recount(rh)
+}
+
+// evict drops a room's buffer
+func (h *historyCache) evict(roomID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if rh, ok := h.rooms[roomID]; ok {
+		h.remove(rh)
+	}
+}
+
+// room returns the buffer for roomID, creating an empty one. h.mu must be held.
+func (h *historyCache) room(roomID string) *roomHistory {
+	rh, ok := h.rooms[roomID]
+	if !ok {
+		rh = &roomHistory{roomID: roomID, floor: -1}
+		rh.elem = h.lru.PushFront(rh)
+		h.rooms[roomID] = rh
+		return rh
+	}
+	h.lru.MoveToFront(rh.elem)
+	return rh
+}
+
+func (h *historyCache) remove(rh *roomHistory) {
+	h.lru.Remove(rh.elem)
+	delete(h.rooms, rh.roomID)
+	h.used -= rh.bytes
+}
+
+// trim drops the oldest messages beyond the per-room limit
+func (h *historyCache) trim(rh *roomHistory) {
+	excess := len(rh.msgs) - h.perRoom
+	if excess <= 0 {
+		return
+	}
+
+	if rh.floor >= 0 {
+		rh.floor = rh.msgs[excess-1].Seq
+	}
+	rh.msgs = append([]*Message(nil), rh.msgs[excess:]...)
+}
+
+// recount updates a buffer's size and evicts other rooms if the budget is exceeded
+func (h *historyCache) recount(rh *roomHistory) {
+	bytes := 0
+	for _, m := range rh.msgs {
+		bytes += messageSize(m)
+	}
+	h.used += bytes - rh.bytes
+	rh.bytes = bytes
+
+	for h.used > h.budget && h.lru.Len() > 1 {
+		victim := h.lru.Back().Value.(*roomHistory)
+		if victim == rh {
+			break
+		}
+		log.Printf("historyCache - Over budget, evicting room %s", victim.roomID)
+		h.remove(victim)
+	}
+}
+
+// patch replaces the cached message with the given ID by a modified copy.
+// Cached messages are shared with readers, so they are never changed in place.
+func (rh *roomHistory) patch(id string, fn func(*Message)) {
+	for i := len(rh.msgs) - 1; i >= 0; i-- {
+		if rh.msgs[i].ID == id {
+			m := *rh.msgs[i]
+			fn(&m)
+			rh.msgs[i] = &m
+			return
+		}
+	}
+}
+
+func messageSize(m *Message) int {
+	n := messageOverhead + len(m.ID) + len(m.Code) + len(m.Content) + len(m.RoomID) +
+		len(m.Username) + len(m.UserID) + len(m.Timestamp) + len(m.EditedAt) +
+		len(m.DeletedAt) + len(m.ReplyTo)
+	for _, r := range m.Reactions {
+		n += 32 + len(r.Emoji)
+	}
+	return n
+}
@@ -1,35 +1,166 @@
 package ws
 
 import (
+	"errors"
+	"log"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"log"
+
+	"github.com/Melkeydev/yappr/internal/ratelimit"
+)
+
+const (
+	// Time allowed to write a frame to the peer
+	writeWait = 10 * time.Second
+
+	// Time allowed to read the next pong from the peer
+	pongWait = 60 * time.Second
+
+	// Pings are sent at this period, which must be shorter than pongWait
+	pingPeriod = (pongWait * 9) / 10
+
+	// Number of outbound frames buffered per client before the slow consumer policy applies
+	sendQueueSize = 256
 )
 
 type Client struct {
+	// Conn is nil for Server-Sent Event streams and HTTP senders
 	Conn     *websocket.Conn
-	Message  chan *Message
+	Message  chan *Envelope
 	ID       string `json:"id"`
 	RoomID   string `json:"room_id"`
 	Username string `json:"username"`
+	Guest    bool   `json:"guest"`
+	Bot      bool   `json:"bot,omitempty"`
+	IP       string `json:"-"`
+
+	// ReadOnly is set for API tokens without the messages:write scope
+	ReadOnly bool `json:"-"`
+
+	// Since is the last sequence number the client saw before reconnecting
+	Since int64 `json:"-"`
+
+	// sendBucket limits this connection's chat frames and typingBucket its
+	// typing frames; only the read goroutine uses them
+	sendBucket   *ratelimit.Bucket
+	typingBucket *ratelimit.Bucket
+
+	// lastRead is the highest read marker seen from this connection; only the room goroutine uses it
+	lastRead int64
+
+	mu          sync.Mutex
+	closed      bool
+	closeCode   int
+	closeReason string
 }
 
 type Message struct {
+	ID        string `json:"id,omitempty"`
+	Seq       int64  `json:"seq,omitempty"`
+	Code      string `json:"code,omitempty"`
 	Content   string `json:"content"`
 	RoomID    string `json:"room_id"`
 	Username  string `json:"username"`
 	UserID    string `json:"user_id,omitempty"`
 	System    bool   `json:"system"`
+	Bot       bool   `json:"bot,omitempty"`
 	Timestamp string `json:"timestamp,omitempty"`
+	EditedAt  string `json:"edited_at,omitempty"`
+	DeletedAt string `json:"deleted_at,omitempty"`
+
+	// ReplyTo references the parent message so clients can render a quote
+	ReplyTo    string `json:"reply_to,omitempty"`
+	ReplyCount int    `json:"reply_count,omitempty"`
+
+	Reactions []Reaction `json:"reactions,omitempty"`
+
+	// ClientID echoes the sender's idempotency key so its other tabs can match
+	// the message to their pending send
+	ClientID string `json:"client_id,omitempty"`
+
+	// flagged holds the filter matches of a message stored for review
+	flagged []string
 }
 
-func (c *Client) ReadMessage(core *Core) {
+// Reaction is the number of users who reacted to a message with one emoji
+type Reaction struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// Inbound is a validated envelope received from a client
+type Inbound struct {
+	Client   *Client
+	Envelope *Envelope
+
+	// reply is set for chat sent over HTTP by a client outside the room
+	reply chan<- sendResult
+}
+
+func NewClient(conn *websocket.Conn, id, roomID, username string, guest bool) *Client {
+	return &Client{
+		Conn:     conn,
+		Message:  make(chan *Envelope, sendQueueSize),
+		ID:       id,
+		RoomID:   roomID,
+		Username: username,
+		Guest:    guest,
+	}
+}
+
+// enqueue queues an envelope without blocking. It returns false when the client's
+// send queue is full or the client has already been closed.
+func (c *Client) enqueue(env *Envelope) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	select {
+	case c.Message <- env:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the write pump, which sends a close frame with the given code
+func (c *Client) close(code int, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.closeCode = code
+	c.closeReason = reason
+	close(c.Message)
+}
+
+func (c *Client) closeStatus() (int, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeCode, c.closeReason
+}
+
+func (c *Client) ReadMessage(room *Room) {
 	defer func() {
-		core.Unregister <- c
+		room.Unregister(c)
 		c.Conn.Close()
 	}()
 
+	// Larger frames fail the read and close the socket with CloseMessageTooBig
+	c.Conn.SetReadLimit(maxFrameSize)
+	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		return c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
 	for {
 		_, m, err := c.Conn.ReadMessage()
 		if err != nil {
@@ -39,29 +170,61 @@ func (c *Client) ReadMessage(core *Core) {
 			break
 		}
 
-		msg := &Message{
-			Content:   string(m),
-			RoomID:    c.RoomID,
-			Username:  c.Username,
-			UserID:    c.ID,
-			Timestamp: time.Now().Format("2006-01-02T15:04:05Z07:00"),
+		env, err := ParseEnvelope(m)
+		if err != nil {
+			var protoErr *ProtocolError
+			if !errors.As(err, &protoErr) {
+				protoErr = &ProtocolError{Code: ErrCodeInvalidPayload, Message: err.Error()}
+			}
+			id := ""
+			if env != nil {
+				id = env.ID
+			}
+			c.enqueue(NewErrorEnvelope(id, protoErr.Code, protoErr.Message))
+			continue
+		}
+
+		if env.Type == EventChat || env.Type == EventCommand {
+			if protoErr := room.admit(c); protoErr != nil {
+				c.enqueue(NewErrorEnvelope(env.ID, protoErr.Code, protoErr.Message))
+				continue
+			}
+		}
+		if env.Type == EventTyping && !c.allowTyping() {
+			continue
 		}
 
-		core.Broadcast <- msg
+		room.handleInbound(&Inbound{Client: c, Envelope: env})
 	}
 }
 
 func (c *Client) WriteMessage() {
+	ticker := time.NewTicker(pingPeriod)
 	defer func() {
+		ticker.Stop()
 		c.Conn.Close()
 	}()
 
 	for {
-		message, ok := <-c.Message
-		if !ok {
-			return
-		}
+		select {
+		case message, ok := <-c.Message:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				code, reason := c.closeStatus()
+				c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+				return
+			}
+
+			if err := c.Conn.WriteJSON(message); err != nil {
+				log.Printf("Client.WriteMessage - Write failed for %s: %v", c.ID, err)
+				return
+			}
 
-		c.Conn.WriteJSON(message)
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
 	}
 }
@@ -3,149 +3,407 @@ package ws
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"log"
+	"sync"
+	"time"
 
-	"github.com/google/uuid"
+	"github.com/Melkeydev/yappr/internal/broker"
+	"github.com/Melkeydev/yappr/internal/filter"
+	notificationRepo "github.com/Melkeydev/yappr/internal/repo/notification"
 	roomRepo "github.com/Melkeydev/yappr/internal/repo/room"
 	statsRepo "github.com/Melkeydev/yappr/internal/repo/stats"
+	userRepo "github.com/Melkeydev/yappr/internal/repo/user"
+	"github.com/Melkeydev/yappr/util"
+	"github.com/google/uuid"
 )
 
-type Room struct {
-	ID               string             `json:"id"`
-	Name             string             `json:"name"`
-	Clients          map[string]*Client `json:"clients"`
-	History          []*Message
-	IsPinned         bool    `json:"is_pinned"`
-	TopicTitle       *string `json:"topic_title,omitempty"`
-	TopicDescription *string `json:"topic_description,omitempty"`
-	TopicURL         *string `json:"topic_url,omitempty"`
-	TopicSource      *string `json:"topic_source,omitempty"`
+// SlowConsumerPolicy decides what happens when a client's send queue is full
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerDrop discards the frame and keeps the client connected
+	SlowConsumerDrop SlowConsumerPolicy = "drop"
+	// SlowConsumerDisconnect closes the client with CloseTryAgainLater
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+// errReplyTargetNotFound is returned when a reply points at a message that isn't in the room
+var errReplyTargetNotFound = errors.New("reply target not found")
+
+// Number of events waiting to be published before new ones are dropped
+const outboxSize = 1024
+
+// RoomInfo is the metadata needed to bring a room online
+type RoomInfo struct {
+	ID               string
+	Name             string
+	Kind             string
+	IsPinned         bool
+	TopicTitle       *string
+	TopicDescription *string
+	TopicURL         *string
+	TopicSource      *string
+	ProfanityAction  *string
 }
 
+// Core is the registry of live rooms. Each room runs its own goroutine, so the
+// registry lock only guards lookups and never a room's member state. Room events
+// are published to the broker so members connected to other instances see them.
 type Core struct {
-	Rooms      map[string]*Room
-	Register   chan *Client
-	Unregister chan *Client
-	Broadcast  chan *Message
-	roomRepo   *roomRepo.RoomRepository
-	statsRepo  *statsRepo.StatsRepository
-	db         *sql.DB
+	mu        sync.RWMutex
+	rooms     map[string]*Room
+	roomRepo  *roomRepo.RoomRepository
+	statsRepo *statsRepo.StatsRepository
+	db        *sql.DB
+
+	userRepo         *userRepo.UserRepository
+	notificationRepo *notificationRepo.NotificationRepository
+
+	// Every local connection by user ID, for events that follow a user across rooms
+	usersMu sync.RWMutex
+	users   map[string]map[*Client]struct{}
+
+	broker     broker.Broker
+	instanceID string
+	outbox     chan *broker.Event
+
+	// Members connected to each room through other instances
+	presence *remotePresence
+
+	slowConsumerPolicy SlowConsumerPolicy
+
+	flood        *floodControl
+	limits       RoomLimits
+	pinnedLimits RoomLimits
+
+	profanity       *filter.ProfanityFilter
+	profanityAction filter.Action
+
+	// Background work and socket writers that Shutdown waits for
+	tasks sync.WaitGroup
+
+	// Closed by EndStreams so event streams don't hold up the HTTP server's shutdown
+	streamsDone chan struct{}
+	endStreams  sync.Once
+
+	stats   *statsWriter
+	history *historyCache
+	sends   *recentSends
 }
 
-func NewCore(db *sql.DB) *Core {
-	return &Core{
-		Rooms:      make(map[string]*Room),
-		Register:   make(chan *Client),
-		Unregister: make(chan *Client),
-		Broadcast:  make(chan *Message, 5),
-		roomRepo:   roomRepo.NewRoomRepository(db),
-		statsRepo:  statsRepo.NewStatsRepository(db),
-		db:         db,
+func NewCore(db *sql.DB, b broker.Broker) *Core {
+	// Slow clients are disconnected by default, WS_SLOW_CONSUMER_POLICY=drop keeps them
+	policy := SlowConsumerDisconnect
+	if util.GetEnv("WS_SLOW_CONSUMER_POLICY", "") == string(SlowConsumerDrop) {
+		policy = SlowConsumerDrop
 	}
-}
 
-func (c *Core) GetDB() *sql.DB {
-	return c.db
+	limits, pinnedLimits := loadRoomLimits()
+
+	c := &Core{
+		rooms:              make(map[string]*Room),
+		roomRepo:           roomRepo.NewRoomRepository(db),
+		statsRepo:          statsRepo.NewStatsRepository(db),
+		db:                 db,
+		userRepo:           userRepo.NewUserRepository(db),
+		notificationRepo:   notificationRepo.NewNotificationRepository(db),
+		users:              make(map[string]map[*Client]struct{}),
+		broker:             b,
+		instanceID:         uuid.NewString(),
+		outbox:             make(chan *broker.Event, outboxSize),
+		presence:           newRemotePresence(),
+		slowConsumerPolicy: policy,
+		flood:              newFloodControl(),
+		limits:             limits,
+		pinnedLimits:       pinnedLimits,
+		profanity:          filter.NewChatFilter(),
+		profanityAction:    filter.ParseAction(util.GetEnv("WS_PROFANITY_ACTION", ""), filter.ActionMask),
+		streamsDone:        make(chan struct{}),
+	}
+	c.history = newHistoryCache()
+	c.sends = newRecentSends()
+	c.stats = newStatsWriter(c, c.statsRepo)
+	go c.stats.run()
+
+	return c
 }
 
-// The core will be ran in a different go Routine
-func (c *Core) Run() {
+// Run relays room events to and from the broker until ctx is cancelled,
+// keeps presence in step with other instances and periodically forgets idle
+// rate limit state
+func (c *Core) Run(ctx context.Context) {
+	c.broker.Subscribe(c.handleRemote)
+	// Learn who is connected elsewhere without waiting for heartbeats
+	c.publish("", broker.KindPresenceSync, nil)
+
+	prune := time.NewTicker(floodPruneInterval)
+	defer prune.Stop()
+	heartbeat := time.NewTicker(presenceHeartbeat)
+	defer heartbeat.Stop()
+
 	for {
 		select {
-		case cl := <-c.Register:
-			if room, ok := c.Rooms[cl.RoomID]; ok {
-				if _, ok := room.Clients[cl.ID]; !ok {
-					room.Clients[cl.ID] = cl
-				}
-				go func() {
-					roomUUID, err := uuid.Parse(cl.RoomID)
-					if err != nil {
-						log.Printf("Invalid room ID: %v", err)
-						return
-					}
-
-					messages, err := c.roomRepo.GetRoomMessages(context.Background(), roomUUID, 100)
-					if err != nil {
-						log.Printf("Failed to load room messages: %v", err)
-						return
-					}
-
-					for _, msg := range messages {
-						userID := ""
-						if msg.UserID != nil {
-							userID = msg.UserID.String()
-						}
-
-						wsMsg := &Message{
-							Content:   msg.Content,
-							RoomID:    cl.RoomID,
-							Username:  msg.Username,
-							UserID:    userID,
-							System:    msg.IsSystem,
-							Timestamp: msg.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-						}
-						cl.Message <- wsMsg
-					}
-				}()
+		case <-prune.C:
+			c.flood.prune()
+			c.sends.prune()
+		case <-heartbeat.C:
+			c.heartbeatPresence()
+			c.expirePresence()
+		case ev := <-c.outbox:
+			if err := c.broker.Publish(ctx, ev); err != nil {
+				log.Printf("Core.Run - Failed to publish %s event for room %s: %v", ev.Kind, ev.RoomID, err)
 			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-		case cl := <-c.Unregister:
-			if _, ok := c.Rooms[cl.RoomID]; ok {
-				if _, ok := c.Rooms[cl.RoomID].Clients[cl.ID]; ok {
-					delete(c.Rooms[cl.RoomID].Clients, cl.ID)
-					close(cl.Message)
-				}
-			}
+// publish queues an event for other instances without blocking the caller
+func (c *Core) publish(roomID, kind string, data any) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Core.publish - Failed to marshal %s event: %v", kind, err)
+		return
+	}
 
-			// FAN OUT
-		case m := <-c.Broadcast:
-			if room, ok := c.Rooms[m.RoomID]; ok {
-				room.History = append(room.History, m)
-
-				go func(msg *Message) {
-					roomUUID, err := uuid.Parse(msg.RoomID)
-					if err != nil {
-						log.Printf("Invalid room ID: %v", err)
-						return
-					}
-
-					var userID *uuid.UUID
-					if msg.UserID != "" {
-						if parsedUserID, err := uuid.Parse(msg.UserID); err == nil {
-							userID = &parsedUserID
-						}
-					}
-
-					dbMsg := &roomRepo.Message{
-						RoomID:   roomUUID,
-						UserID:   userID,
-						Username: msg.Username,
-						Content:  msg.Content,
-						IsSystem: msg.System,
-					}
-
-					if _, err := c.roomRepo.CreateMessage(context.Background(), dbMsg); err != nil {
-						log.Printf("Failed to persist message: %v", err)
-					}
-
-					if userID != nil {
-						if err := c.statsRepo.IncrementMessageCount(context.Background(), *userID); err != nil {
-							log.Printf("Failed to update message count for user %s: %v", userID.String(), err)
-						} else {
-							go func() {
-								_, err := c.statsRepo.CheckAndAwardAchievements(context.Background(), *userID)
-								if err != nil {
-									log.Printf("Error checking achievements for message sender %s: %v", userID.String(), err)
-								}
-							}()
-						}
-					}
-				}(m)
-
-				for _, cl := range room.Clients {
-					cl.Message <- m
-				}
-			}
+	ev := &broker.Event{Origin: c.instanceID, RoomID: roomID, Kind: kind, Data: raw}
+	select {
+	case c.outbox <- ev:
+	default:
+		log.Printf("Core.publish - Outbox full, dropping %s event for room %s", kind, roomID)
+	}
+}
+
+// handleRemote applies an event published by another instance
+func (c *Core) handleRemote(ev *broker.Event) {
+	if ev.Origin == c.instanceID {
+		return
+	}
+
+	switch ev.Kind {
+	case broker.KindEnvelope:
+		room, ok := c.GetRoom(ev.RoomID)
+		if !ok {
+			// Nobody is connected to this room here
+			return
+		}
+		var env Envelope
+		if err := json.Unmarshal(ev.Data, &env); err != nil {
+			log.Printf("Core.handleRemote - Malformed envelope for room %s: %v", ev.RoomID, err)
+			return
+		}
+		room.relay(&env)
+
+	case broker.KindRoomClosed:
+		var reason string
+		_ = json.Unmarshal(ev.Data, &reason)
+		c.closeLocal(ev.RoomID, reason)
+
+	case broker.KindUserEnvelope:
+		var env Envelope
+		if err := json.Unmarshal(ev.Data, &env); err != nil {
+			log.Printf("Core.handleRemote - Malformed envelope for user %s: %v", ev.UserID, err)
+			return
 		}
+		c.sendToLocalUser(ev.UserID, &env)
+
+	case broker.KindPresence:
+		var st presenceState
+		if err := json.Unmarshal(ev.Data, &st); err != nil {
+			log.Printf("Core.handleRemote - Malformed presence for room %s: %v", ev.RoomID, err)
+			return
+		}
+		c.presence.set(ev.RoomID, ev.Origin, st.Members)
+		if room, ok := c.GetRoom(ev.RoomID); ok {
+			room.presenceChanged()
+		}
+
+	case broker.KindPresenceSync:
+		c.heartbeatPresence()
+	}
+}
+
+func (c *Core) GetDB() *sql.DB {
+	return c.db
+}
+
+// GetOrCreateRoom returns the live room for info.ID, starting it if needed
+func (c *Core) GetOrCreateRoom(info RoomInfo) *Room {
+	c.mu.RLock()
+	room, ok := c.rooms[info.ID]
+	c.mu.RUnlock()
+	if ok {
+		return room
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if room, ok := c.rooms[info.ID]; ok {
+		return room
+	}
+
+	room = newRoom(c, info)
+	c.rooms[info.ID] = room
+	go room.run()
+
+	return room
+}
+
+// GetRoom returns the live room with the given ID, if any
+func (c *Core) GetRoom(id string) (*Room, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	room, ok := c.rooms[id]
+	return room, ok
+}
+
+// CloseRoom disconnects a room's clients on this and every other instance
+func (c *Core) CloseRoom(id string, reason string) bool {
+	c.publish(id, broker.KindRoomClosed, reason)
+	return c.closeLocal(id, reason)
+}
+
+// closeLocal removes a room from the registry and disconnects its local clients
+func (c *Core) closeLocal(id string, reason string) bool {
+	c.mu.Lock()
+	room, ok := c.rooms[id]
+	if ok {
+		delete(c.rooms, id)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	room.stop(reason)
+	return true
+}
+
+// Snapshot returns the rooms that are currently live
+func (c *Core) Snapshot() []*Room {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	rooms := make([]*Room, 0, len(c.rooms))
+	for _, room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// loadHistory fetches the most recent messages from the database for join replay
+func (c *Core) loadHistory(roomID string, limit int) ([]*Message, error) {
+	roomUUID, err := uuid.Parse(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := c.roomRepo.GetRoomMessages(context.Background(), roomUUID, limit)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.roomRepo.LoadReactions(context.Background(), messages); err != nil {
+		return nil, err
+	}
+
+	return messagesFromRows(messages), nil
+}
+
+// loadHistorySince fetches messages sent after the given sequence number
+func (c *Core) loadHistorySince(roomID string, since int64, limit int) ([]*Message, error) {
+	roomUUID, err := uuid.Parse(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := c.roomRepo.GetRoomMessagesSince(context.Background(), roomUUID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.roomRepo.LoadReactions(context.Background(), messages); err != nil {
+		return nil, err
+	}
+
+	return messagesFromRows(messages), nil
+}
+
+func messagesFromRows(rows []*roomRepo.Message) []*Message {
+	history := make([]*Message, 0, len(rows))
+	for _, msg := range rows {
+		history = append(history, NewMessageFromRow(msg))
+	}
+	return history
+}
+
+// NewMessageFromRow converts a stored message to its socket form. Deleted
+// messages come back as tombstones with empty content.
+func NewMessageFromRow(msg *roomRepo.Message) *Message {
+	m := &Message{
+		ID:         msg.ID.String(),
+		Seq:        msg.Seq,
+		Content:    msg.Content,
+		RoomID:     msg.RoomID.String(),
+		Username:   msg.Username,
+		System:     msg.IsSystem,
+		Bot:        msg.IsBot,
+		Timestamp:  msg.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		ReplyCount: msg.ReplyCount,
+	}
+	if msg.ReplyTo != nil {
+		m.ReplyTo = msg.ReplyTo.String()
+	}
+	if msg.UserID != nil {
+		m.UserID = msg.UserID.String()
+	}
+	if msg.EditedAt != nil {
+		m.EditedAt = msg.EditedAt.Format("2006-01-02T15:04:05Z07:00")
+	}
+	if msg.DeletedAt != nil {
+		m.DeletedAt = msg.DeletedAt.Format("2006-01-02T15:04:05Z07:00")
+	}
+	for _, rc := range msg.Reactions {
+		m.Reactions = append(m.Reactions, Reaction{Emoji: rc.Emoji, Count: rc.Count})
+	}
+	return m
+}
+
+// PublishUpdate tells every connected client, on any instance, that a stored
+// message was edited or deleted so they can patch their view
+func (c *Core) PublishUpdate(msg *roomRepo.Message) {
+	m := NewMessageFromRow(msg)
+	c.publishToRoom(m.RoomID, NewEnvelope(EventUpdate, m))
+}
+
+// PublishReaction pushes an incremental reaction change to the room
+func (c *Core) PublishReaction(roomID string, p ReactionPayload) {
+	c.publishToRoom(roomID, NewEnvelope(EventReaction, p))
+}
+
+// publishToRoom delivers a stored change to the room's local clients and to other instances
+func (c *Core) publishToRoom(roomID string, env *Envelope) {
+	c.publish(roomID, broker.KindEnvelope, env)
+	if room, ok := c.GetRoom(roomID); ok {
+		room.relay(env)
+	}
+}
+
+// NewRoomInfo builds room metadata from a database row
+func NewRoomInfo(room *roomRepo.Room) RoomInfo {
+	return RoomInfo{
+		ID:               room.ID.String(),
+		Name:             room.Name,
+		Kind:             room.Kind,
+		IsPinned:         room.IsPinned,
+		TopicTitle:       room.TopicTitle,
+		TopicDescription: room.TopicDescription,
+		TopicURL:         room.TopicURL,
+		TopicSource:      room.TopicSource,
+		ProfanityAction:  room.ProfanityAction,
 	}
 }
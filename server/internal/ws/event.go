@@ -0,0 +1,291 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// ProtocolVersion is the current version of the socket envelope format
+const ProtocolVersion = 1
+
+// EventType identifies the kind of payload carried by an Envelope
+type EventType string
+
+const (
+	EventChat     EventType = "chat"
+	EventTyping   EventType = "typing"
+	EventPresence EventType = "presence"
+	EventAck      EventType = "ack"
+	EventError    EventType = "error"
+	EventSystem   EventType = "system"
+	EventCommand  EventType = "command"
+	EventUpdate   EventType = "update"
+	EventReaction EventType = "reaction"
+	EventRead     EventType = "read"
+
+	EventNotification EventType = "notification"
+)
+
+// Error codes sent back to clients in error frames
+const (
+	ErrCodeInvalidJSON        = "invalid_json"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeInvalidPayload     = "invalid_payload"
+	ErrCodeUnknownCommand     = "unknown_command"
+	ErrCodeRoomBusy           = "room_busy"
+	ErrCodePersistFailed      = "persist_failed"
+	ErrCodeInvalidReply       = "invalid_reply"
+	ErrCodeRateLimited        = "rate_limited"
+	ErrCodeMuted              = "muted"
+	ErrCodeInvalidEncoding    = "invalid_encoding"
+	ErrCodeEmptyMessage       = "empty_message"
+	ErrCodeMessageTooLong     = "message_too_long"
+	ErrCodeTooManyLines       = "too_many_lines"
+	ErrCodeProfanity          = "profanity"
+	ErrCodeAuthRequired       = "auth_required"
+	ErrCodeReadOnly           = "read_only"
+)
+
+const (
+	maxEnvelopeIDLength = 64
+	maxClientIDLength   = 64
+)
+
+// Envelope is the wire format for every frame sent over the room socket
+type Envelope struct {
+	Version int             `json:"v"`
+	Type    EventType       `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// ChatPayload is the payload a client sends with a chat event
+type ChatPayload struct {
+	Content string `json:"content"`
+	// ReplyTo is the ID of the message being replied to, if any
+	ReplyTo string `json:"reply_to,omitempty"`
+	// ClientID is a client-generated key for the send. Resending with the same
+	// key after a reconnect is acknowledged without storing a second copy.
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// TypingPayload is sent by clients to signal typing and relayed to other members
+type TypingPayload struct {
+	Typing   bool   `json:"typing"`
+	UserID   string `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+// Presence actions carried by a PresencePayload
+const (
+	PresenceJoined   = "joined"
+	PresenceLeft     = "left"
+	PresenceSnapshot = "snapshot"
+)
+
+// PresencePayload announces a member joining or leaving, or lists everyone
+// in the room for a client that has just connected
+type PresencePayload struct {
+	Action  string   `json:"action"`
+	Member  *Member  `json:"member,omitempty"`
+	Members []Member `json:"members,omitempty"`
+}
+
+// ReadPayload moves the sender's read cursor in the room to Seq. The server
+// sends it back with RoomID set to every connection of that user, so other
+// devices can clear their unread state.
+type ReadPayload struct {
+	RoomID string `json:"room_id,omitempty"`
+	Seq    int64  `json:"seq"`
+}
+
+// AckPayload confirms to the sender that a chat message was stored. Duplicate
+// is set when the send repeated a client ID that had already been stored.
+type AckPayload struct {
+	ClientID  string `json:"client_id,omitempty"`
+	MessageID string `json:"message_id"`
+	Seq       int64  `json:"seq"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
+
+// ReactionPayload announces a reaction being added or removed. Count is the
+// new total for that emoji so clients can apply it without recounting.
+type ReactionPayload struct {
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+	UserID    string `json:"user_id"`
+	Added     bool   `json:"added"`
+	Count     int    `json:"count"`
+}
+
+// NotificationPayload is pushed to a user when a notification is stored for them.
+// It has the same shape as a notification returned by the API.
+type NotificationPayload struct {
+	ID            string `json:"id"`
+	Kind          string `json:"kind"`
+	RoomID        string `json:"room_id"`
+	RoomName      string `json:"room_name"`
+	MessageID     string `json:"message_id,omitempty"`
+	ActorUsername string `json:"actor_username"`
+	Excerpt       string `json:"excerpt"`
+	Link          string `json:"link"`
+	Read          bool   `json:"read"`
+	CreatedAt     string `json:"created_at"`
+}
+
+// CommandPayload carries a slash-style command from a client
+type CommandPayload struct {
+	Name string   `json:"name"`
+	Args []string `json:"args,omitempty"`
+}
+
+// ErrorPayload is sent back to a client when one of its frames is rejected
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ProtocolError describes why an inbound frame was rejected
+type ProtocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Message
+}
+
+func newProtocolError(code, format string, args ...any) *ProtocolError {
+	return &ProtocolError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// NewEnvelope wraps a payload in an envelope of the given type
+func NewEnvelope(t EventType, payload any) *Envelope {
+	env := &Envelope{Version: ProtocolVersion, Type: t}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			// Payloads are server-defined structs, so this should never happen
+			panic(fmt.Sprintf("ws: marshal %s payload: %v", t, err))
+		}
+		env.Payload = data
+	}
+	return env
+}
+
+// NewErrorEnvelope builds an error frame that references the offending envelope ID
+func NewErrorEnvelope(id string, code, message string) *Envelope {
+	env := NewEnvelope(EventError, ErrorPayload{Code: code, Message: message})
+	env.ID = id
+	return env
+}
+
+// newMessageEnvelope wraps a chat message, using the system type for system lines
+func newMessageEnvelope(m *Message) *Envelope {
+	if m.System {
+		return NewEnvelope(EventSystem, m)
+	}
+	return NewEnvelope(EventChat, m)
+}
+
+// ParseEnvelope decodes and validates a frame received from a client
+func ParseEnvelope(data []byte) (*Envelope, error) {
+	// Decoding would silently replace invalid bytes, so check the frame first
+	if !utf8.Valid(data) {
+		return nil, newProtocolError(ErrCodeInvalidEncoding, "frame must be valid UTF-8")
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, newProtocolError(ErrCodeInvalidJSON, "frame is not a valid envelope")
+	}
+
+	if env.Version == 0 {
+		env.Version = ProtocolVersion
+	}
+	if env.Version != ProtocolVersion {
+		return &env, newProtocolError(ErrCodeUnsupportedVersion, "unsupported protocol version %d", env.Version)
+	}
+
+	if len(env.ID) > maxEnvelopeIDLength {
+		// The id isn't echoed back, a cut-off copy wouldn't match the client's anyway
+		return nil, newProtocolError(ErrCodeInvalidPayload, "envelope id must be at most %d characters", maxEnvelopeIDLength)
+	}
+
+	switch env.Type {
+	case EventChat:
+		var p ChatPayload
+		if err := decodePayload(env.Payload, &p); err != nil {
+			return &env, err
+		}
+		if protoErr := validateChat(&p); protoErr != nil {
+			return &env, protoErr
+		}
+		// Downstream only ever sees the sanitized text
+		env.Payload, _ = json.Marshal(p)
+	case EventTyping:
+		var p TypingPayload
+		if err := decodePayload(env.Payload, &p); err != nil {
+			return &env, err
+		}
+	case EventRead:
+		var p ReadPayload
+		if err := decodePayload(env.Payload, &p); err != nil {
+			return &env, err
+		}
+		if p.Seq < 0 {
+			return &env, newProtocolError(ErrCodeInvalidPayload, "seq must not be negative")
+		}
+	case EventCommand:
+		var p CommandPayload
+		if err := decodePayload(env.Payload, &p); err != nil {
+			return &env, err
+		}
+		if p.Name == "" {
+			return &env, newProtocolError(ErrCodeInvalidPayload, "command name is required")
+		}
+	case EventPresence, EventAck, EventError, EventSystem, EventUpdate, EventReaction, EventNotification:
+		return &env, newProtocolError(ErrCodeUnknownType, "event type %q can only be sent by the server", env.Type)
+	default:
+		return &env, newProtocolError(ErrCodeUnknownType, "unknown event type %q", env.Type)
+	}
+
+	return &env, nil
+}
+
+// validateChat checks a chat payload and sanitizes its content in place
+func validateChat(p *ChatPayload) *ProtocolError {
+	content, protoErr := SanitizeContent(p.Content)
+	if protoErr != nil {
+		return protoErr
+	}
+	if p.ReplyTo != "" {
+		if _, err := uuid.Parse(p.ReplyTo); err != nil {
+			return newProtocolError(ErrCodeInvalidReply, "reply_to must be a message ID")
+		}
+	}
+	if len(p.ClientID) > maxClientIDLength {
+		return newProtocolError(ErrCodeInvalidPayload, "client_id must be at most %d characters", maxClientIDLength)
+	}
+	p.Content = content
+	return nil
+}
+
+// DecodePayload unmarshals the envelope payload into v
+func (e *Envelope) DecodePayload(v any) error {
+	return decodePayload(e.Payload, v)
+}
+
+func decodePayload(raw json.RawMessage, v any) error {
+	if len(raw) == 0 {
+		return newProtocolError(ErrCodeInvalidPayload, "payload is required")
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return newProtocolError(ErrCodeInvalidPayload, "payload is malformed")
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package ws
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Melkeydev/yappr/internal/ratelimit"
+	"github.com/Melkeydev/yappr/util"
+)
+
+const (
+	// Throttled frames within strikeWindow that get a sender muted
+	muteAfterStrikes = 5
+	strikeWindow     = 30 * time.Second
+	muteDuration     = time.Minute
+
+	// How often idle buckets and expired strikes are forgotten
+	floodPruneInterval = time.Minute
+)
+
+// RoomLimits are the send limits applied in a room. Conn limits a single
+// socket; User limits a user (or a guest's IP) across all of their sockets.
+type RoomLimits struct {
+	Conn ratelimit.Limit
+	User ratelimit.Limit
+}
+
+// loadRoomLimits reads the limits for regular and pinned rooms from the
+// environment. Pinned rooms are busier, so they default to stricter limits.
+func loadRoomLimits() (regular, pinned RoomLimits) {
+	regular = RoomLimits{
+		Conn: envLimit("WS_RATE_CONN", ratelimit.Limit{Rate: 1, Burst: 5}),
+		User: envLimit("WS_RATE_USER", ratelimit.Limit{Rate: 2, Burst: 8}),
+	}
+	pinned = RoomLimits{
+		Conn: envLimit("WS_RATE_PINNED_CONN", ratelimit.Limit{Rate: 0.5, Burst: 3}),
+		User: envLimit("WS_RATE_PINNED_USER", ratelimit.Limit{Rate: 1, Burst: 4}),
+	}
+	return regular, pinned
+}
+
+// envLimit reads <prefix>_PER_SEC and <prefix>_BURST, keeping def for anything unset or invalid
+func envLimit(prefix string, def ratelimit.Limit) ratelimit.Limit {
+	if v, err := strconv.ParseFloat(util.GetEnv(prefix+"_PER_SEC", ""), 64); err == nil && v > 0 {
+		def.Rate = v
+	}
+	if v, err := strconv.Atoi(util.GetEnv(prefix+"_BURST", "")); err == nil && v > 0 {
+		def.Burst = v
+	}
+	return def
+}
+
+// offender tracks recent throttling of one sender in one room
+type offender struct {
+	strikes    []time.Time
+	mutedUntil time.Time
+}
+
+// floodControl holds the cross-connection limits and mutes for every room on this instance
+type floodControl struct {
+	users *ratelimit.Limiter
+
+	mu        sync.Mutex
+	offenders map[string]*offender
+}
+
+func newFloodControl() *floodControl {
+	return &floodControl{
+		users:     ratelimit.NewLimiter(),
+		offenders: make(map[string]*offender),
+	}
+}
+
+// mutedUntil returns when key's mute ends, or the zero time if it isn't muted
+func (f *floodControl) mutedUntil(key string, now time.Time) time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if o, ok := f.offenders[key]; ok && now.Before(o.mutedUntil) {
+		return o.mutedUntil
+	}
+	return time.Time{}
+}
+
+// strike records a throttled frame and mutes key once it has too many
+func (f *floodControl) strike(key string, now time.Time) time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	o, ok := f.offenders[key]
+	if !ok {
+		o = &offender{}
+		f.offenders[key] = o
+	}
+
+	recent := o.strikes[:0]
+	for _, t := range o.strikes {
+		if now.Sub(t) < strikeWindow {
+			recent = append(recent, t)
+		}
+	}
+	o.strikes = append(recent, now)
+
+	if len(o.strikes) >= muteAfterStrikes {
+		o.strikes = nil
+		o.mutedUntil = now.Add(muteDuration)
+		return o.mutedUntil
+	}
+	return time.Time{}
+}
+
+func (f *floodControl) prune() {
+	f.users.Prune()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for key, o := range f.offenders {
+		stale := len(o.strikes) == 0 || now.Sub(o.strikes[len(o.strikes)-1]) >= strikeWindow
+		if stale && now.After(o.mutedUntil) {
+			delete(f.offenders, key)
+		}
+	}
+}
+
+// senderKey identifies a sender across connections. Guests can mint new
+// identities freely, so they are limited by IP instead.
+func senderKey(cl *Client) string {
+	if cl.Guest && cl.IP != "" {
+		return "ip:" + cl.IP
+	}
+	return "user:" + cl.ID
+}
+
+// allowTyping reports whether a typing frame fits the connection's typing
+// limit. It runs on the client's read goroutine.
+func (c *Client) allowTyping() bool {
+	if c.typingBucket == nil {
+		c.typingBucket = ratelimit.NewBucket(typingLimit)
+	}
+	return c.typingBucket.Allow(time.Now())
+}
+
+// admit applies the room's send limits to a chat or command frame. It runs on
+// the client's read goroutine, before the frame reaches the room.
+func (r *Room) admit(cl *Client) *ProtocolError {
+	if cl.ReadOnly {
+		return newProtocolError(ErrCodeReadOnly, "this token can't post messages")
+	}
+
+	now := time.Now()
+	key := r.ID + "|" + senderKey(cl)
+	flood := r.core.flood
+
+	if until := flood.mutedUntil(key, now); !until.IsZero() {
+		return newProtocolError(ErrCodeMuted, "you are muted for flooding, try again in %s", until.Sub(now).Round(time.Second))
+	}
+
+	if cl.sendBucket == nil {
+		cl.sendBucket = ratelimit.NewBucket(r.limits.Conn)
+	}
+	// Only take the connection's token once the user's limit lets the frame
+	// through, so a frame denied by one limit isn't charged to the other
+	denied := r.limits.Conn
+	if cl.sendBucket.Ready(now) {
+		if flood.users.Allow(key, r.limits.User) {
+			cl.sendBucket.Allow(now)
+			return nil
+		}
+		denied = r.limits.User
+	}
+
+	if until := flood.strike(key, now); !until.IsZero() {
+		return newProtocolError(ErrCodeMuted, "you are muted for %s for sending too fast", muteDuration)
+	}
+	return &ProtocolError{Code: ErrCodeRateLimited, Message: fmt.Sprintf("slow down, at most %g messages per second", denied.Rate)}
+}
@@ -0,0 +1,167 @@
+package ws
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// Number of messages replayed to a client when it joins a room
+	historyReplayLimit = 100
+
+	// Largest gap a resuming client is sent before history is truncated
+	maxResumeGap = 200
+
+	// System message code marking that part of the history was skipped
+	CodeHistoryTruncated = "history_truncated"
+
+	// System message code sent just before a room disconnects everyone
+	CodeRoomClosed = "room_closed"
+
+	// System message code sent to every client before a graceful shutdown
+	CodeServerRestarting = "server_restarting"
+)
+
+// pendingMessage is a chat message waiting to be persisted
+type pendingMessage struct {
+	msg *Message
+	err error
+
+	// sender is nil for messages the server posts itself
+	sender *ackTarget
+
+	// Resends of the same client ID that arrived while this one was in flight
+	waiters []ackTarget
+	// duplicate is set when the client ID had already been stored; msg then
+	// carries the original's ID and sequence number
+	duplicate bool
+}
+
+// queuedEnvelope is live traffic held for a client while its history loads
+type queuedEnvelope struct {
+	env *Envelope
+	seq int64
+}
+
+// replayResult is the history loaded for a newly registered client
+type replayResult struct {
+	client    *Client
+	since     int64
+	messages  []*Message
+	truncated bool
+}
+
+// loadReplay fetches the client's history off the room loop. A client resuming
+// with Since gets exactly the messages it missed, unless the gap is too large.
+func (r *Room) loadReplay(cl *Client) {
+	res := &replayResult{client: cl, since: cl.Since}
+
+	var err error
+	if cl.Since > 0 {
+		res.messages, err = r.historySince(cl.Since, maxResumeGap+1)
+		if err == nil && len(res.messages) > maxResumeGap {
+			res.truncated = true
+			res.messages, err = r.latestHistory(historyReplayLimit)
+		}
+	} else {
+		res.messages, err = r.latestHistory(historyReplayLimit)
+	}
+
+	if err != nil {
+		log.Printf("Failed to load room messages: %v", err)
+		res.messages = nil
+	}
+
+	select {
+	case r.replayed <- res:
+	case <-r.done:
+	}
+}
+
+// latestHistory returns the room's last limit messages from the history cache.
+// On a miss, usually the room's first join, it reads a full buffer from the
+// database and warms the cache with it.
+func (r *Room) latestHistory(limit int) ([]*Message, error) {
+	if msgs, ok := r.core.history.latest(r.ID, limit); ok {
+		return msgs, nil
+	}
+
+	r.core.history.reserve(r.ID)
+	load := max(limit, r.core.history.perRoom)
+	msgs, err := r.core.loadHistory(r.ID, load)
+	if err != nil {
+		return nil, err
+	}
+	r.core.history.fill(r.ID, msgs, historyFloor(msgs, load))
+
+	if len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+	return msgs, nil
+}
+
+// historySince is latestHistory for a client resuming after seq
+func (r *Room) historySince(seq int64, limit int) ([]*Message, error) {
+	if msgs, ok := r.core.history.since(r.ID, seq, limit); ok {
+		return msgs, nil
+	}
+
+	r.core.history.reserve(r.ID)
+	msgs, err := r.core.loadHistorySince(r.ID, seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) < limit {
+		// Everything after seq came back
+		r.core.history.fill(r.ID, msgs, seq)
+	}
+	return msgs, nil
+}
+
+// historyFloor is the sequence number a page of the latest messages starts
+// after. A short page is the whole room.
+func historyFloor(msgs []*Message, limit int) int64 {
+	if len(msgs) < limit || len(msgs) == 0 {
+		return 0
+	}
+	return msgs[0].Seq - 1
+}
+
+// finishReplay sends the loaded history followed by any live traffic that arrived
+// meanwhile, skipping messages the history already covered
+func (r *Room) finishReplay(res *replayResult) {
+	cl := res.client
+	held, ok := r.replaying[cl]
+	if !ok {
+		// The client left before its history was ready
+		return
+	}
+	delete(r.replaying, cl)
+
+	if res.truncated {
+		r.deliver(cl, newMessageEnvelope(&Message{
+			Code:      CodeHistoryTruncated,
+			Content:   fmt.Sprintf("You missed more than %d messages, showing the most recent ones.", maxResumeGap),
+			RoomID:    r.ID,
+			Username:  "system",
+			System:    true,
+			Timestamp: time.Now().Format("2006-01-02T15:04:05Z07:00"),
+		}))
+	}
+
+	lastSeq := res.since
+	for _, m := range res.messages {
+		r.deliver(cl, newMessageEnvelope(m))
+		if m.Seq > lastSeq {
+			lastSeq = m.Seq
+		}
+	}
+
+	for _, q := range held {
+		if q.seq > 0 && q.seq <= lastSeq {
+			continue
+		}
+		r.deliver(cl, q.env)
+	}
+}
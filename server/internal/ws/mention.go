@@ -0,0 +1,167 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Melkeydev/yappr/internal/broker"
+	notificationRepo "github.com/Melkeydev/yappr/internal/repo/notification"
+)
+
+const (
+	// Mentions beyond this many in one message are ignored
+	maxMentionsPerMessage = 10
+
+	// Longest excerpt of the message stored with a notification
+	maxExcerptLength = 140
+)
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9_.\-]{1,32})`)
+
+// ParseMentions returns the distinct usernames @mentioned in content, in order
+func ParseMentions(content string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		// A trailing dot is punctuation, as in "thanks @sam."
+		name := strings.TrimRight(match[1], ".")
+		key := strings.ToLower(name)
+		if name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		names = append(names, name)
+		if len(names) == maxMentionsPerMessage {
+			break
+		}
+	}
+	return names
+}
+
+// notifyMentions stores a notification for every registered user mentioned in
+// a chat message and pushes it to them wherever they are connected. Users who
+// aren't in the room are notified too; the notification carries the room ID.
+func (c *Core) notifyMentions(msg *Message, roomName string) {
+	names := ParseMentions(msg.Content)
+	if len(names) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	users, err := c.userRepo.GetUsersByUsernames(ctx, names)
+	if err != nil {
+		log.Printf("Core.notifyMentions - Failed to resolve mentions in room %s: %v", msg.RoomID, err)
+		return
+	}
+
+	roomID, err := uuid.Parse(msg.RoomID)
+	if err != nil {
+		return
+	}
+	var messageID *uuid.UUID
+	if id, err := uuid.Parse(msg.ID); err == nil {
+		messageID = &id
+	}
+	var actorID *uuid.UUID
+	if id, err := uuid.Parse(msg.UserID); err == nil {
+		actorID = &id
+	}
+
+	excerpt := msg.Content
+	if runes := []rune(excerpt); len(runes) > maxExcerptLength {
+		excerpt = string(runes[:maxExcerptLength-1]) + "…"
+	}
+
+	for _, user := range users {
+		if user.ID.String() == msg.UserID {
+			continue
+		}
+
+		n := &notificationRepo.Notification{
+			UserID:        user.ID,
+			Kind:          notificationRepo.KindMention,
+			RoomID:        roomID,
+			RoomName:      roomName,
+			MessageID:     messageID,
+			ActorID:       actorID,
+			ActorUsername: msg.Username,
+			Excerpt:       excerpt,
+		}
+		if err := c.notificationRepo.CreateNotification(ctx, n); err != nil {
+			log.Printf("Core.notifyMentions - Failed to notify %s: %v", user.ID, err)
+			continue
+		}
+
+		payload := NotificationPayload{
+			ID:            n.ID.String(),
+			Kind:          n.Kind,
+			RoomID:        msg.RoomID,
+			RoomName:      roomName,
+			MessageID:     msg.ID,
+			ActorUsername: msg.Username,
+			Excerpt:       excerpt,
+			Link:          "/chat/" + msg.RoomID,
+			CreatedAt:     n.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		}
+		c.SendToUser(user.ID.String(), NewEnvelope(EventNotification, payload))
+	}
+}
+
+// SendToUser delivers an envelope to every connection of a user, on this and
+// every other instance. Delivery is best effort: full send queues drop it.
+func (c *Core) SendToUser(userID string, env *Envelope) {
+	c.sendToLocalUser(userID, env)
+
+	raw, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("Core.SendToUser - Failed to marshal envelope: %v", err)
+		return
+	}
+	ev := &broker.Event{Origin: c.instanceID, UserID: userID, Kind: broker.KindUserEnvelope, Data: raw}
+	select {
+	case c.outbox <- ev:
+	default:
+		log.Printf("Core.SendToUser - Outbox full, dropping event for user %s", userID)
+	}
+}
+
+func (c *Core) sendToLocalUser(userID string, env *Envelope) {
+	c.usersMu.RLock()
+	defer c.usersMu.RUnlock()
+
+	for cl := range c.users[userID] {
+		cl.enqueue(env)
+	}
+}
+
+// trackClient indexes a connection by user so it can be reached outside its room
+func (c *Core) trackClient(cl *Client) {
+	c.usersMu.Lock()
+	defer c.usersMu.Unlock()
+
+	conns, ok := c.users[cl.ID]
+	if !ok {
+		conns = make(map[*Client]struct{})
+		c.users[cl.ID] = conns
+	}
+	conns[cl] = struct{}{}
+}
+
+func (c *Core) untrackClient(cl *Client) {
+	c.usersMu.Lock()
+	defer c.usersMu.Unlock()
+
+	conns := c.users[cl.ID]
+	delete(conns, cl)
+	if len(conns) == 0 {
+		delete(c.users, cl.ID)
+	}
+}
@@ -0,0 +1,30 @@
+package ws
+
+import (
+	"github.com/Melkeydev/yappr/internal/filter"
+)
+
+// ProfanityActionFor resolves a room's configured filter action, falling back
+// to the server default (WS_PROFANITY_ACTION) when the room has none
+func (c *Core) ProfanityActionFor(setting *string) filter.Action {
+	if setting == nil {
+		return c.profanityAction
+	}
+	return filter.ParseAction(*setting, c.profanityAction)
+}
+
+// ModerateContent runs chat content through the profanity filter. It returns
+// the content to store, the spans that matched, and whether the message must
+// be refused.
+func (c *Core) ModerateContent(action filter.Action, content string) (string, []filter.Match, bool) {
+	return c.profanity.Moderate(content, action)
+}
+
+// MatchedTerms lists the text of each match, for storing alongside a flag
+func MatchedTerms(matches []filter.Match) []string {
+	terms := make([]string, 0, len(matches))
+	for _, m := range matches {
+		terms = append(terms, m.Text)
+	}
+	return terms
+}
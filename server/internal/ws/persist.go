@@ -0,0 +1,424 @@
+package ws
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	roomRepo "github.com/Melkeydev/yappr/internal/repo/room"
+	statsRepo "github.com/Melkeydev/yappr/internal/repo/stats"
+)
+
+const (
+	// Most chat messages stored by one room in a single insert
+	persistBatchSize = 64
+	// How long a room waits for more messages once one is queued
+	persistBatchWindow = 5 * time.Millisecond
+
+	// Attempts for a batch write before giving up, with the backoff doubling from persistRetryDelay
+	persistAttempts   = 3
+	persistRetryDelay = 50 * time.Millisecond
+	// Deadline for each write attempt
+	persistTimeout = 5 * time.Second
+
+	// Users with pending stats deltas before the stats writer flushes early
+	statsBatchSize = 256
+	// How often buffered stats deltas are written
+	statsFlushInterval = 2 * time.Second
+	// Batches of deltas waiting for the stats writer before rooms block
+	statsQueueSize = 64
+)
+
+// collectBatch gathers the messages queued behind first until the batch is
+// full or the window closes. It reports false once the queue has been closed.
+func (r *Room) collectBatch(first *pendingMessage) ([]*pendingMessage, bool) {
+	batch := []*pendingMessage{first}
+
+	window := time.NewTimer(persistBatchWindow)
+	defer window.Stop()
+
+	for len(batch) < persistBatchSize {
+		select {
+		case p, ok := <-r.persistQ:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, p)
+		case <-window.C:
+			return batch, true
+		case <-r.done:
+			return batch, true
+		}
+	}
+	return batch, true
+}
+
+// storeMessages persists a room's batch of chat messages in send order. Each
+// message is stamped with its server ID and sequence number, or its pending
+// entry gets the error that kept it from being stored. Senders' stats are
+// handed to the stats writer.
+func (c *Core) storeMessages(roomID string, batch []*pendingMessage) {
+	fail := func(err error) {
+		for _, p := range batch {
+			if p.err == nil && !p.duplicate {
+				p.err = err
+			}
+		}
+	}
+
+	roomUUID, err := uuid.Parse(roomID)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	// Replies must point at a live message in this room
+	var parentIDs []uuid.UUID
+	for _, p := range batch {
+		if p.msg.ReplyTo == "" {
+			continue
+		}
+		parentID, err := uuid.Parse(p.msg.ReplyTo)
+		if err != nil {
+			p.err = errReplyTargetNotFound
+			continue
+		}
+		parentIDs = append(parentIDs, parentID)
+	}
+	var live map[uuid.UUID]bool
+	if len(parentIDs) > 0 {
+		err := c.retry("check reply targets", func(ctx context.Context) error {
+			var err error
+			live, err = c.roomRepo.GetLiveMessageIDs(ctx, roomUUID, parentIDs)
+			return err
+		})
+		if err != nil {
+			fail(err)
+			return
+		}
+	}
+
+	// A unique violation means a resend's client ID was stored by another
+	// instance after we checked, so check again and skip it this time
+	var pending []*pendingMessage
+	var rows []*roomRepo.Message
+	for attempt := 0; ; attempt++ {
+		if err := c.markDuplicates(batch); err != nil {
+			fail(err)
+			return
+		}
+
+		pending, rows = pending[:0], rows[:0]
+		for _, p := range batch {
+			if p.err != nil || p.duplicate {
+				continue
+			}
+			row, err := newMessageRow(roomUUID, p.msg, live)
+			if err != nil {
+				p.err = err
+				continue
+			}
+			pending = append(pending, p)
+			rows = append(rows, row)
+		}
+		if len(rows) == 0 {
+			return
+		}
+
+		err = c.retryWrite("store messages", func(ctx context.Context) error {
+			return c.roomRepo.CreateMessages(ctx, roomUUID, rows)
+		})
+		if err == nil {
+			break
+		}
+		if attempt > 0 || !isUniqueViolation(err) {
+			fail(err)
+			return
+		}
+	}
+
+	deltas := make(map[uuid.UUID]int)
+	for i, p := range pending {
+		row := rows[i]
+		p.msg.ID = row.ID.String()
+		p.msg.Seq = row.Seq
+		p.msg.Timestamp = row.CreatedAt.Format("2006-01-02T15:04:05Z07:00")
+
+		if len(p.msg.flagged) > 0 {
+			// The message is already stored, so a failed flag shouldn't fail the send
+			err := c.retryWrite("flag message", func(ctx context.Context) error {
+				return c.roomRepo.FlagMessage(ctx, row.ID, roomRepo.FlagReasonProfanity, p.msg.flagged)
+			})
+			if err != nil {
+				log.Printf("Core.storeMessages - Failed to flag message %s: %v", row.ID, err)
+			}
+		}
+
+		if row.UserID != nil {
+			deltas[*row.UserID]++
+		}
+	}
+	c.stats.add(deltas)
+}
+
+// newMessageRow builds the row for a chat message, checking its reply target
+// against the live parents found for the batch
+func newMessageRow(roomID uuid.UUID, m *Message, live map[uuid.UUID]bool) (*roomRepo.Message, error) {
+	row := &roomRepo.Message{
+		RoomID:   roomID,
+		Username: m.Username,
+		Content:  m.Content,
+		IsSystem: m.System,
+		IsBot:    m.Bot,
+	}
+	if m.UserID != "" {
+		if userID, err := uuid.Parse(m.UserID); err == nil {
+			row.UserID = &userID
+		}
+	}
+	if m.ReplyTo != "" {
+		parentID := uuid.MustParse(m.ReplyTo)
+		if !live[parentID] {
+			return nil, errReplyTargetNotFound
+		}
+		row.ReplyTo = &parentID
+	}
+	if m.ClientID != "" {
+		clientID := m.ClientID
+		row.ClientID = &clientID
+	}
+	return row, nil
+}
+
+// markDuplicates finds sends in the batch whose client ID was already stored
+// for the same user and stamps them with the original message
+func (c *Core) markDuplicates(batch []*pendingMessage) error {
+	var keys []roomRepo.ClientKey
+	for _, p := range batch {
+		if p.err != nil || p.duplicate || p.msg.ClientID == "" {
+			continue
+		}
+		// Guests have no stored user, so only the in-memory window covers them
+		if userID, err := uuid.Parse(p.msg.UserID); err == nil {
+			keys = append(keys, roomRepo.ClientKey{UserID: userID, ClientID: p.msg.ClientID})
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var stored []*roomRepo.Message
+	err := c.retry("check client IDs", func(ctx context.Context) error {
+		var err error
+		stored, err = c.roomRepo.GetMessagesByClientKeys(ctx, keys)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	byKey := make(map[string]*roomRepo.Message, len(stored))
+	for _, m := range stored {
+		byKey[sendKey(m.UserID.String(), *m.ClientID)] = m
+	}
+	for _, p := range batch {
+		if p.msg.ClientID == "" {
+			continue
+		}
+		if m, ok := byKey[sendKey(p.msg.UserID, p.msg.ClientID)]; ok {
+			p.duplicate = true
+			p.msg.ID = m.ID.String()
+			p.msg.Seq = m.Seq
+			p.msg.Timestamp = m.CreatedAt.Format("2006-01-02T15:04:05Z07:00")
+		}
+	}
+	return nil
+}
+
+// retry runs a database read, retrying transient failures with backoff
+func (c *Core) retry(op string, fn func(ctx context.Context) error) error {
+	return c.retryIf(op, isTransient, fn)
+}
+
+// retryWrite runs a database write that isn't safe to repeat. It is only
+// retried when the database is known not to have applied it; a timeout or a
+// connection dropped mid-statement may have committed it, so those fail.
+func (c *Core) retryWrite(op string, fn func(ctx context.Context) error) error {
+	return c.retryIf(op, isRejected, fn)
+}
+
+func (c *Core) retryIf(op string, retryable func(error) bool, fn func(ctx context.Context) error) error {
+	delay := persistRetryDelay
+	var err error
+	for attempt := 1; attempt <= persistAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+		err = fn(ctx)
+		cancel()
+		if err == nil || !retryable(err) {
+			return err
+		}
+
+		if attempt < persistAttempts {
+			log.Printf("Core.retry - %s failed (attempt %d), retrying in %s: %v", op, attempt, delay, err)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return err
+}
+
+// isTransient reports whether a read is worth retrying: dropped connections,
+// timeouts, serialization failures and deadlocks
+func isTransient(err error) bool {
+	if isRejected(err) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr)
+}
+
+// isRejected reports whether a statement failed transiently without being
+// applied: the server refused or rolled it back, or database/sql never sent it
+func isRejected(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case strings.HasPrefix(pgErr.Code, "08"), // connection exception
+			pgErr.Code == "40001", // serialization_failure
+			pgErr.Code == "40P01", // deadlock_detected
+			pgErr.Code == "53300", // too_many_connections
+			pgErr.Code == "57P01": // admin_shutdown
+			return true
+		}
+		return false
+	}
+	return errors.Is(err, driver.ErrBadConn)
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// statsWriter buffers per-user message counts and writes them in batches, then
+// checks achievements once per user instead of once per message
+type statsWriter struct {
+	repo *statsRepo.StatsRepository
+	core *Core
+	in   chan map[uuid.UUID]int
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newStatsWriter(core *Core, repo *statsRepo.StatsRepository) *statsWriter {
+	return &statsWriter{
+		repo: repo,
+		core: core,
+		in:   make(chan map[uuid.UUID]int, statsQueueSize),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// add queues deltas, blocking while the writer is behind
+func (w *statsWriter) add(deltas map[uuid.UUID]int) {
+	if len(deltas) == 0 {
+		return
+	}
+	select {
+	case w.in <- deltas:
+	case <-w.done:
+		log.Printf("statsWriter.add - Writer stopped, dropping stats for %d users", len(deltas))
+	}
+}
+
+func (w *statsWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(statsFlushInterval)
+	defer ticker.Stop()
+
+	pending := make(map[uuid.UUID]int)
+	for {
+		select {
+		case deltas := <-w.in:
+			mergeDeltas(pending, deltas)
+			if len(pending) >= statsBatchSize {
+				pending = w.flush(pending)
+			}
+
+		case <-ticker.C:
+			pending = w.flush(pending)
+
+		case <-w.quit:
+			// Pick up whatever rooms queued before the stop
+			for drained := false; !drained; {
+				select {
+				case deltas := <-w.in:
+					mergeDeltas(pending, deltas)
+				default:
+					drained = true
+				}
+			}
+			w.flush(pending)
+			return
+		}
+	}
+}
+
+func mergeDeltas(pending, deltas map[uuid.UUID]int) {
+	for userID, n := range deltas {
+		pending[userID] += n
+	}
+}
+
+// flush writes pending deltas and returns an empty map to buffer into
+func (w *statsWriter) flush(pending map[uuid.UUID]int) map[uuid.UUID]int {
+	if len(pending) == 0 {
+		return pending
+	}
+
+	err := w.core.retryWrite("add message counts", func(ctx context.Context) error {
+		return w.repo.AddMessageCounts(ctx, pending)
+	})
+	if err != nil {
+		log.Printf("statsWriter.flush - Dropping message counts for %d users: %v", len(pending), err)
+		return make(map[uuid.UUID]int)
+	}
+
+	for userID := range pending {
+		ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+		if _, err := w.repo.CheckAndAwardAchievements(ctx, userID); err != nil {
+			log.Printf("Error checking achievements for message sender %s: %v", userID.String(), err)
+		}
+		cancel()
+	}
+	return make(map[uuid.UUID]int)
+}
+
+// stop flushes buffered deltas and waits for the writer to exit
+func (w *statsWriter) stop(ctx context.Context) error {
+	close(w.quit)
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,319 @@
+package ws
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Melkeydev/yappr/internal/broker"
+)
+
+const (
+	// How long a user's last connection can be gone before they are announced as
+	// having left. A reconnect inside this window produces no leave/join events.
+	presenceGrace = 5 * time.Second
+
+	// How often each instance republishes who is connected to its rooms
+	presenceHeartbeat = 10 * time.Second
+	// How long another instance's members count without a heartbeat. An
+	// instance that crashes has its members announced as left after this.
+	presenceTTL = 3 * presenceHeartbeat
+)
+
+// pendingLeave is a user whose last connection dropped and who may still come back
+type pendingLeave struct {
+	member Member
+	timer  *time.Timer
+}
+
+// presenceState is the set of members one instance has in a room, as
+// published to the others. An empty list withdraws the instance's entry.
+type presenceState struct {
+	Members []Member `json:"members"`
+}
+
+// instancePresence is what another instance last said about a room
+type instancePresence struct {
+	members []Member
+	seen    time.Time
+}
+
+// remotePresence holds the members other instances have in each room, by
+// room and then instance. Entries expire unless refreshed by heartbeats.
+type remotePresence struct {
+	mu    sync.Mutex
+	rooms map[string]map[string]*instancePresence
+}
+
+func newRemotePresence() *remotePresence {
+	return &remotePresence{rooms: make(map[string]map[string]*instancePresence)}
+}
+
+// set records an instance's members in a room
+func (p *remotePresence) set(roomID, instanceID string, members []Member) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	instances, ok := p.rooms[roomID]
+	if len(members) == 0 {
+		if ok {
+			delete(instances, instanceID)
+			if len(instances) == 0 {
+				delete(p.rooms, roomID)
+			}
+		}
+		return
+	}
+	if !ok {
+		instances = make(map[string]*instancePresence)
+		p.rooms[roomID] = instances
+	}
+	instances[instanceID] = &instancePresence{members: members, seen: time.Now()}
+}
+
+// members returns every member other instances have in the room. The same
+// user may appear more than once.
+func (p *remotePresence) members(roomID string) []Member {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var members []Member
+	for _, ip := range p.rooms[roomID] {
+		members = append(members, ip.members...)
+	}
+	return members
+}
+
+// expire drops entries of instances that stopped sending heartbeats and
+// returns the rooms whose members changed
+func (p *remotePresence) expire(now time.Time) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var changed []string
+	for roomID, instances := range p.rooms {
+		stale := false
+		for instanceID, ip := range instances {
+			if now.Sub(ip.seen) > presenceTTL {
+				delete(instances, instanceID)
+				stale = true
+			}
+		}
+		if len(instances) == 0 {
+			delete(p.rooms, roomID)
+		}
+		if stale {
+			changed = append(changed, roomID)
+		}
+	}
+	return changed
+}
+
+// addPresence counts a new connection for its user. The first connection of a
+// user on any instance announces them to the room; every connection gets a
+// member snapshot.
+func (r *Room) addPresence(cl *Client) {
+	r.conns[cl.ID]++
+
+	if pl, ok := r.leaving[cl.ID]; ok {
+		// Came back within the grace window, the room never saw them leave
+		pl.timer.Stop()
+		delete(r.leaving, cl.ID)
+	} else if r.conns[cl.ID] == 1 {
+		r.publishPresence()
+	}
+	r.syncPresence(cl)
+
+	r.deliver(cl, NewEnvelope(EventPresence, PresencePayload{
+		Action:  PresenceSnapshot,
+		Members: r.memberList(),
+	}))
+}
+
+// dropPresence uncounts a connection. When it was the user's last one, the
+// leave is announced only if they don't reconnect within presenceGrace.
+func (r *Room) dropPresence(cl *Client) {
+	r.conns[cl.ID]--
+	if r.conns[cl.ID] > 0 {
+		return
+	}
+	delete(r.conns, cl.ID)
+
+	pl := &pendingLeave{member: Member{ID: cl.ID, Username: cl.Username}}
+	pl.timer = time.AfterFunc(presenceGrace, func() {
+		select {
+		case r.leaveExpired <- pl:
+		case <-r.done:
+		}
+	})
+	r.leaving[cl.ID] = pl
+}
+
+// finishLeave drops a user whose grace window ran out without a reconnect.
+// They are announced as left unless still connected to another instance.
+func (r *Room) finishLeave(pl *pendingLeave) {
+	if r.leaving[pl.member.ID] != pl {
+		// They reconnected, or left again and a newer timer owns the leave
+		return
+	}
+	delete(r.leaving, pl.member.ID)
+
+	r.publishPresence()
+	r.syncPresence(nil)
+}
+
+// localMembers lists the users connected to this instance, including those
+// inside their grace window
+func (r *Room) localMembers() []Member {
+	seen := make(map[string]bool, len(r.conns)+len(r.leaving))
+	members := make([]Member, 0, len(r.conns)+len(r.leaving))
+	for cl := range r.clients {
+		if !seen[cl.ID] {
+			seen[cl.ID] = true
+			members = append(members, Member{ID: cl.ID, Username: cl.Username})
+		}
+	}
+	for id, pl := range r.leaving {
+		if !seen[id] {
+			seen[id] = true
+			members = append(members, pl.member)
+		}
+	}
+	return members
+}
+
+// memberList collapses connections on every instance into one entry per user
+func (r *Room) memberList() []Member {
+	local := r.localMembers()
+	remote := r.core.presence.members(r.ID)
+
+	seen := make(map[string]bool, len(local)+len(remote))
+	members := make([]Member, 0, len(local)+len(remote))
+	for _, m := range append(local, remote...) {
+		if seen[m.ID] {
+			continue
+		}
+		seen[m.ID] = true
+		members = append(members, m)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Username < members[j].Username
+	})
+	return members
+}
+
+// syncPresence tells local clients, except skip, who joined or left since the
+// last sync. Every instance announces to its own clients, so presence events
+// are never relayed through the broker.
+func (r *Room) syncPresence(skip *Client) {
+	current := r.memberList()
+	now := make(map[string]Member, len(current))
+	for _, m := range current {
+		now[m.ID] = m
+		if _, ok := r.announced[m.ID]; !ok {
+			r.announce(skip, NewEnvelope(EventPresence, PresencePayload{
+				Action: PresenceJoined,
+				Member: &m,
+			}))
+		}
+	}
+	for id, m := range r.announced {
+		if _, ok := now[id]; !ok {
+			r.announce(nil, NewEnvelope(EventPresence, PresencePayload{
+				Action: PresenceLeft,
+				Member: &m,
+			}))
+		}
+	}
+	r.announced = now
+}
+
+// publishPresence tells other instances who is connected here
+func (r *Room) publishPresence() {
+	r.core.publish(r.ID, broker.KindPresence, presenceState{Members: r.localMembers()})
+}
+
+// heartbeatPresence refreshes this instance's entry on the others
+func (r *Room) heartbeatPresence() {
+	if len(r.conns) > 0 || len(r.leaving) > 0 {
+		r.publishPresence()
+	}
+}
+
+// announce sends a presence event to every local client except skip
+func (r *Room) announce(skip *Client, env *Envelope) {
+	for cl := range r.clients {
+		if cl != skip {
+			r.deliver(cl, env)
+		}
+	}
+}
+
+// presenceChanged asks the room to sync after another instance's members changed
+func (r *Room) presenceChanged() {
+	select {
+	case r.presenceSync <- struct{}{}:
+	default:
+		// A sync is already pending and will see this change too
+	}
+}
+
+// requestHeartbeat asks the room to republish its members
+func (r *Room) requestHeartbeat() {
+	select {
+	case r.heartbeat <- struct{}{}:
+	default:
+	}
+}
+
+// stopPresence cancels pending leaves when the room shuts down and withdraws
+// this instance's members from the others, instead of leaving them to expire
+func (r *Room) stopPresence() {
+	if len(r.conns) == 0 && len(r.leaving) == 0 {
+		return
+	}
+	for id, pl := range r.leaving {
+		pl.timer.Stop()
+		delete(r.leaving, id)
+	}
+	clear(r.conns)
+	r.core.publish(r.ID, broker.KindPresence, presenceState{})
+}
+
+// Members returns the users connected to a room on any instance, one entry per user
+func (c *Core) Members(roomID string) []Member {
+	if room, ok := c.GetRoom(roomID); ok {
+		return room.Members()
+	}
+
+	seen := make(map[string]bool)
+	members := []Member{}
+	for _, m := range c.presence.members(roomID) {
+		if !seen[m.ID] {
+			seen[m.ID] = true
+			members = append(members, m)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Username < members[j].Username
+	})
+	return members
+}
+
+// expirePresence drops members of instances that stopped sending heartbeats
+// and lets the affected rooms announce them as left
+func (c *Core) expirePresence() {
+	for _, roomID := range c.presence.expire(time.Now()) {
+		if room, ok := c.GetRoom(roomID); ok {
+			room.presenceChanged()
+		}
+	}
+}
+
+// heartbeatPresence republishes every live room's local members
+func (c *Core) heartbeatPresence() {
+	for _, room := range c.Snapshot() {
+		room.requestHeartbeat()
+	}
+}
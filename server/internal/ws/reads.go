@@ -0,0 +1,75 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MarkRead stores a user's read cursor for a room and tells every connection
+// of that user, on any instance, so their other devices stay in sync. It
+// returns the cursor as stored, which never moves backwards.
+func (c *Core) MarkRead(ctx context.Context, userID, roomID string, seq int64) (int64, error) {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return 0, fmt.Errorf("invalid user ID: %w", err)
+	}
+	roomUUID, err := uuid.Parse(roomID)
+	if err != nil {
+		return 0, fmt.Errorf("invalid room ID: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := c.roomRepo.MarkRead(ctx, userUUID, roomUUID, seq)
+	if err != nil {
+		return 0, err
+	}
+
+	c.SendToUser(userID, NewEnvelope(EventRead, ReadPayload{RoomID: roomID, Seq: cursor}))
+	return cursor, nil
+}
+
+// How long a room collects read markers before storing them. Clients send one
+// per message scrolled past, so each user gets at most one write per window.
+const readFlushDelay = time.Second
+
+// queueRead records a user's read marker to be stored with the next flush
+func (r *Room) queueRead(userID string, seq int64) {
+	if seq > r.pendingReads[userID] {
+		r.pendingReads[userID] = seq
+	}
+	if r.readTimer == nil {
+		r.readTimer = time.AfterFunc(readFlushDelay, func() {
+			select {
+			case r.readFlush <- struct{}{}:
+			case <-r.done:
+			}
+		})
+	}
+}
+
+// flushReads stores the latest marker of every user heard from since the last flush
+func (r *Room) flushReads() {
+	if r.readTimer != nil {
+		r.readTimer.Stop()
+		r.readTimer = nil
+	}
+	if len(r.pendingReads) == 0 {
+		return
+	}
+
+	reads, roomID := r.pendingReads, r.ID
+	r.pendingReads = make(map[string]int64)
+	r.core.Go(func() {
+		for userID, seq := range reads {
+			if _, err := r.core.MarkRead(context.Background(), userID, roomID, seq); err != nil {
+				log.Printf("Room.flushReads - Failed to mark room %s read for %s: %v", roomID, userID, err)
+			}
+		}
+	})
+}
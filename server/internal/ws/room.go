@@ -0,0 +1,587 @@
+package ws
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/Melkeydev/yappr/internal/broker"
+	"github.com/Melkeydev/yappr/internal/filter"
+	roomRepo "github.com/Melkeydev/yappr/internal/repo/room"
+)
+
+// Number of chat messages waiting to be persisted before senders are told the room is busy
+const persistQueueSize = 256
+
+// Member is a user currently connected to a room
+type Member struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// Room is a single chat room. All member state is owned by the room's own
+// goroutine and only touched through its channels.
+type Room struct {
+	ID               string  `json:"id"`
+	Name             string  `json:"name"`
+	Kind             string  `json:"kind"`
+	IsPinned         bool    `json:"is_pinned"`
+	TopicTitle       *string `json:"topic_title,omitempty"`
+	TopicDescription *string `json:"topic_description,omitempty"`
+	TopicURL         *string `json:"topic_url,omitempty"`
+	TopicSource      *string `json:"topic_source,omitempty"`
+
+	core       *Core
+	limits     RoomLimits
+	clients    map[*Client]struct{}
+	register   chan *Client
+	unregister chan *Client
+	broadcast  chan *Message
+	inbound    chan *Inbound
+	remote     chan *Envelope
+	persistQ   chan *pendingMessage
+	persisted  chan *pendingMessage
+	replayed   chan *replayResult
+	replaying  map[*Client][]queuedEnvelope
+	inflight   map[string]*pendingMessage
+	members    chan chan []Member
+
+	// Connections per user and users inside their reconnect grace window
+	conns        map[string]int
+	leaving      map[string]*pendingLeave
+	leaveExpired chan *pendingLeave
+
+	// Members on every instance as last announced to local clients
+	announced    map[string]Member
+	presenceSync chan struct{}
+	heartbeat    chan struct{}
+
+	typing        map[*Client]*typingState
+	typingSent    map[*Client]time.Time
+	typingExpired chan *typingState
+
+	// Read markers waiting to be stored, by user; readTimer is nil when none are
+	pendingReads map[string]int64
+	readTimer    *time.Timer
+	readFlush    chan struct{}
+
+	// What happens to chat messages that trip the profanity filter
+	profanityAction filter.Action
+
+	quit     chan stopRequest
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newRoom(core *Core, info RoomInfo) *Room {
+	limits := core.limits
+	if info.IsPinned {
+		limits = core.pinnedLimits
+	}
+
+	return &Room{
+		ID:               info.ID,
+		Name:             info.Name,
+		Kind:             info.Kind,
+		IsPinned:         info.IsPinned,
+		TopicTitle:       info.TopicTitle,
+		TopicDescription: info.TopicDescription,
+		TopicURL:         info.TopicURL,
+		TopicSource:      info.TopicSource,
+		core:             core,
+		limits:           limits,
+		profanityAction:  core.ProfanityActionFor(info.ProfanityAction),
+		clients:          make(map[*Client]struct{}),
+		register:         make(chan *Client),
+		unregister:       make(chan *Client),
+		broadcast:        make(chan *Message, 16),
+		inbound:          make(chan *Inbound, 16),
+		remote:           make(chan *Envelope, 64),
+		persistQ:         make(chan *pendingMessage, persistQueueSize),
+		persisted:        make(chan *pendingMessage, 16),
+		replayed:         make(chan *replayResult),
+		replaying:        make(map[*Client][]queuedEnvelope),
+		inflight:         make(map[string]*pendingMessage),
+		members:          make(chan chan []Member),
+		conns:            make(map[string]int),
+		leaving:          make(map[string]*pendingLeave),
+		leaveExpired:     make(chan *pendingLeave),
+		announced:        make(map[string]Member),
+		presenceSync:     make(chan struct{}, 1),
+		heartbeat:        make(chan struct{}, 1),
+		typing:           make(map[*Client]*typingState),
+		typingSent:       make(map[*Client]time.Time),
+		typingExpired:    make(chan *typingState),
+		pendingReads:     make(map[string]int64),
+		readFlush:        make(chan struct{}),
+		quit:             make(chan stopRequest, 1),
+		done:             make(chan struct{}),
+	}
+}
+
+// Register adds a client to the room. It returns false if the room has closed.
+func (r *Room) Register(cl *Client) bool {
+	select {
+	case r.register <- cl:
+		return true
+	case <-r.done:
+		cl.close(websocket.ClosePolicyViolation, "room closed")
+		return false
+	}
+}
+
+// Unregister removes a client from the room
+func (r *Room) Unregister(cl *Client) {
+	select {
+	case r.unregister <- cl:
+	case <-r.done:
+	}
+}
+
+// Broadcast sends a server-originated message to everyone in the room
+func (r *Room) Broadcast(m *Message) {
+	select {
+	case r.broadcast <- m:
+	case <-r.done:
+	}
+}
+
+// Members returns the users currently connected, one entry per user
+func (r *Room) Members() []Member {
+	reply := make(chan []Member, 1)
+	select {
+	case r.members <- reply:
+		return <-reply
+	case <-r.done:
+		return []Member{}
+	}
+}
+
+func (r *Room) handleInbound(in *Inbound) {
+	select {
+	case r.inbound <- in:
+	case <-r.done:
+	}
+}
+
+// relay delivers an already persisted envelope to local clients
+func (r *Room) relay(env *Envelope) {
+	select {
+	case r.remote <- env:
+	case <-r.done:
+	}
+}
+
+// stop asks the room goroutine to disconnect everyone and exit
+func (r *Room) stop(reason string) {
+	r.requestStop(stopRequest{reason: reason})
+}
+
+// restart asks the room goroutine to flush accepted messages, tell clients the
+// server is restarting and exit. Flushing gives up once deadline is closed.
+func (r *Room) restart(deadline <-chan struct{}) {
+	r.requestStop(stopRequest{restart: true, deadline: deadline})
+}
+
+func (r *Room) requestStop(req stopRequest) {
+	r.stopOnce.Do(func() {
+		r.quit <- req
+	})
+}
+
+func (r *Room) run() {
+	go r.persistLoop()
+
+	for {
+		select {
+		case cl := <-r.register:
+			r.clients[cl] = struct{}{}
+			r.core.trackClient(cl)
+			// Live traffic is held back until the client's history has been sent
+			r.replaying[cl] = []queuedEnvelope{}
+			r.addPresence(cl)
+			go r.loadReplay(cl)
+
+		case cl := <-r.unregister:
+			r.removeClient(cl, websocket.CloseNormalClosure, "")
+
+		case in := <-r.inbound:
+			r.dispatch(in)
+
+		case m := <-r.broadcast:
+			r.submit(m, nil)
+
+		case p := <-r.persisted:
+			r.handlePersisted(p)
+
+		case res := <-r.replayed:
+			r.finishReplay(res)
+
+		case env := <-r.remote:
+			r.relayRemote(env)
+
+		case reply := <-r.members:
+			reply <- r.memberList()
+
+		case pl := <-r.leaveExpired:
+			r.finishLeave(pl)
+
+		case <-r.presenceSync:
+			r.syncPresence(nil)
+
+		case <-r.heartbeat:
+			r.heartbeatPresence()
+
+		case st := <-r.typingExpired:
+			r.expireTyping(st)
+
+		case <-r.readFlush:
+			r.flushReads()
+
+		case req := <-r.quit:
+			if req.restart {
+				r.drain(req.deadline)
+			}
+			r.shutdown(req)
+			return
+		}
+	}
+}
+
+// dispatch routes a validated client envelope based on its event type
+func (r *Room) dispatch(in *Inbound) {
+	cl := in.Client
+	env := in.Envelope
+
+	// HTTP senders never join the room
+	if _, ok := r.clients[cl]; !ok && in.reply == nil {
+		return
+	}
+
+	switch env.Type {
+	case EventChat:
+		sender := ackTarget{client: cl, envID: env.ID, reply: in.reply}
+
+		var p ChatPayload
+		if err := env.DecodePayload(&p); err != nil {
+			r.reject(sender, ErrCodeInvalidPayload, err.Error())
+			return
+		}
+
+		if p.ClientID != "" {
+			key := sendKey(cl.ID, p.ClientID)
+			if pending, ok := r.inflight[key]; ok {
+				// A resend racing the original: ack both once it's stored
+				pending.waiters = append(pending.waiters, sender)
+				return
+			}
+			if sent, ok := r.core.sends.get(key); ok {
+				r.ack(sender, p.ClientID, sent.ID, sent.Seq, true)
+				return
+			}
+		}
+
+		content, matches, rejected := r.core.ModerateContent(r.profanityAction, p.Content)
+		if rejected {
+			r.reject(sender, ErrCodeProfanity, "message contains inappropriate language")
+			return
+		}
+
+		// Sending a message ends the sender's typing indicator
+		r.clearTyping(cl)
+		m := &Message{
+			Content:   content,
+			RoomID:    r.ID,
+			Username:  cl.Username,
+			UserID:    cl.ID,
+			Bot:       cl.Bot,
+			ReplyTo:   p.ReplyTo,
+			ClientID:  p.ClientID,
+			Timestamp: time.Now().Format("2006-01-02T15:04:05Z07:00"),
+		}
+		if r.profanityAction == filter.ActionFlag {
+			m.flagged = MatchedTerms(matches)
+		}
+		r.submit(m, &sender)
+
+	case EventTyping:
+		var p TypingPayload
+		if err := env.DecodePayload(&p); err != nil {
+			r.deliver(cl, NewErrorEnvelope(env.ID, ErrCodeInvalidPayload, err.Error()))
+			return
+		}
+
+		r.handleTyping(cl, p.Typing)
+
+	case EventRead:
+		var p ReadPayload
+		if err := env.DecodePayload(&p); err != nil {
+			r.deliver(cl, NewErrorEnvelope(env.ID, ErrCodeInvalidPayload, err.Error()))
+			return
+		}
+		if cl.Guest {
+			r.deliver(cl, NewErrorEnvelope(env.ID, ErrCodeAuthRequired, "sign in to keep track of what you've read"))
+			return
+		}
+		// Clients mark read as messages scroll by, so only forward progress is stored
+		if p.Seq <= cl.lastRead {
+			return
+		}
+		cl.lastRead = p.Seq
+		r.queueRead(cl.ID, p.Seq)
+
+	case EventCommand:
+		var p CommandPayload
+		if err := env.DecodePayload(&p); err != nil {
+			r.deliver(cl, NewErrorEnvelope(env.ID, ErrCodeInvalidPayload, err.Error()))
+			return
+		}
+		r.runCommand(cl, env.ID, p)
+
+	default:
+		r.deliver(cl, NewErrorEnvelope(env.ID, ErrCodeUnknownType, "unsupported event type"))
+	}
+}
+
+// runCommand executes a client command and replies only to the sender
+func (r *Room) runCommand(cl *Client, id string, cmd CommandPayload) {
+	switch strings.ToLower(cmd.Name) {
+	case "who":
+		members := r.memberList()
+		names := make([]string, 0, len(members))
+		for _, m := range members {
+			names = append(names, m.Username)
+		}
+
+		reply := newMessageEnvelope(&Message{
+			Content:   "In this room: " + strings.Join(names, ", "),
+			RoomID:    r.ID,
+			Username:  "system",
+			System:    true,
+			Timestamp: time.Now().Format("2006-01-02T15:04:05Z07:00"),
+		})
+		reply.ID = id
+		r.deliver(cl, reply)
+	default:
+		r.deliver(cl, NewErrorEnvelope(id, ErrCodeUnknownCommand, "unknown command: "+cmd.Name))
+	}
+}
+
+// submit hands a message to the persistence loop. Messages are only fanned out
+// once stored, so every client sees them with their final ID and sequence number.
+func (r *Room) submit(m *Message, sender *ackTarget) {
+	p := &pendingMessage{msg: m, sender: sender}
+	select {
+	case r.persistQ <- p:
+		if m.ClientID != "" {
+			r.inflight[sendKey(m.UserID, m.ClientID)] = p
+		}
+	default:
+		log.Printf("Room.submit - Persist queue full for room %s", r.ID)
+		if sender != nil {
+			r.reject(*sender, ErrCodeRoomBusy, "room is busy, try again")
+		}
+	}
+}
+
+// persistLoop stores messages in batches, one batch at a time, so sequence
+// numbers follow send order. A full persist queue pushes back on senders.
+func (r *Room) persistLoop() {
+	for {
+		var first *pendingMessage
+		select {
+		case p, ok := <-r.persistQ:
+			if !ok {
+				// The room is draining and everything queued has been stored
+				close(r.persisted)
+				return
+			}
+			first = p
+		case <-r.done:
+			return
+		}
+
+		batch, open := r.collectBatch(first)
+		r.core.storeMessages(r.ID, batch)
+		for _, p := range batch {
+			select {
+			case r.persisted <- p:
+			case <-r.done:
+				return
+			}
+		}
+
+		if !open {
+			close(r.persisted)
+			return
+		}
+	}
+}
+
+func (r *Room) handlePersisted(p *pendingMessage) {
+	var targets []ackTarget
+	if p.sender != nil {
+		targets = append(targets, *p.sender)
+	}
+	targets = append(targets, p.waiters...)
+	if p.msg.ClientID != "" {
+		delete(r.inflight, sendKey(p.msg.UserID, p.msg.ClientID))
+	}
+
+	if p.err != nil {
+		log.Printf("Room.handlePersisted - Failed to persist message in room %s: %v", r.ID, p.err)
+		code, text := ErrCodePersistFailed, "message could not be sent"
+		if errors.Is(p.err, errReplyTargetNotFound) {
+			code, text = ErrCodeInvalidReply, "the message you replied to no longer exists"
+		}
+		for _, t := range targets {
+			r.reject(t, code, text)
+		}
+		return
+	}
+
+	if p.msg.ClientID != "" {
+		r.core.sends.remember(sendKey(p.msg.UserID, p.msg.ClientID), p.msg)
+	}
+	for i, t := range targets {
+		// Everything after the original send is a resend
+		duplicate := p.duplicate || p.sender == nil || i > 0
+		r.ack(t, p.msg.ClientID, p.msg.ID, p.msg.Seq, duplicate)
+	}
+	if p.duplicate {
+		// Delivered to the room when it was first stored
+		return
+	}
+
+	r.fanOut(p.msg)
+	// Both members of a direct room already see every message
+	if !p.msg.System && r.Kind != roomRepo.KindDirect {
+		msg, name := p.msg, r.Name
+		r.core.Go(func() { r.core.notifyMentions(msg, name) })
+	}
+}
+
+// fanOut delivers a stored message to every client in the room and to other instances
+func (r *Room) fanOut(m *Message) {
+	r.core.history.append(r.ID, m)
+
+	env := newMessageEnvelope(m)
+	for cl := range r.clients {
+		r.deliverSeq(cl, env, m.Seq)
+	}
+	r.core.publish(r.ID, broker.KindEnvelope, env)
+}
+
+// relayRemote delivers an envelope from another instance, or an update made
+// through the API. It is already persisted, so it is only fanned out here.
+func (r *Room) relayRemote(env *Envelope) {
+	var seq int64
+	switch env.Type {
+	case EventChat, EventSystem:
+		var m Message
+		if err := env.DecodePayload(&m); err == nil {
+			r.core.history.append(r.ID, &m)
+			seq = m.Seq
+		}
+	case EventUpdate:
+		var m Message
+		if err := env.DecodePayload(&m); err == nil {
+			r.core.history.update(r.ID, &m)
+		}
+	case EventReaction:
+		var p ReactionPayload
+		if err := env.DecodePayload(&p); err == nil {
+			r.core.history.react(r.ID, p)
+		}
+	}
+
+	for cl := range r.clients {
+		r.deliverSeq(cl, env, seq)
+	}
+}
+
+// deliver queues an envelope for a client without ever blocking the room loop
+func (r *Room) deliver(cl *Client, env *Envelope) {
+	r.deliverSeq(cl, env, 0)
+}
+
+// deliverSeq is deliver for envelopes carrying a stored message. While a client is
+// still receiving its history, live envelopes are held so they can be de-duplicated.
+func (r *Room) deliverSeq(cl *Client, env *Envelope, seq int64) {
+	if held, ok := r.replaying[cl]; ok {
+		if len(held) < sendQueueSize {
+			r.replaying[cl] = append(held, queuedEnvelope{env: env, seq: seq})
+			return
+		}
+		log.Printf("Room.deliver - Too much traffic while replaying history to %s", cl.ID)
+		r.removeClient(cl, websocket.CloseTryAgainLater, "client too slow")
+		return
+	}
+
+	if cl.enqueue(env) {
+		return
+	}
+
+	switch r.core.slowConsumerPolicy {
+	case SlowConsumerDrop:
+		log.Printf("Room.deliver - Dropped %s frame for slow client %s", env.Type, cl.ID)
+	default:
+		log.Printf("Room.deliver - Disconnecting slow client %s", cl.ID)
+		r.removeClient(cl, websocket.CloseTryAgainLater, "client too slow")
+	}
+}
+
+// removeClient detaches a client from the room and closes its send queue
+func (r *Room) removeClient(cl *Client, code int, reason string) {
+	if _, ok := r.clients[cl]; ok {
+		delete(r.clients, cl)
+		r.core.untrackClient(cl)
+		r.dropTyping(cl)
+		r.dropPresence(cl)
+		if len(r.clients) == 0 {
+			// Nobody here to replay to; the next join warms it again
+			r.core.history.evict(r.ID)
+		}
+	}
+	delete(r.replaying, cl)
+	cl.close(code, reason)
+}
+
+// shutdown tells every client the room is gone, or that the server is
+// restarting, and disconnects them
+func (r *Room) shutdown(req stopRequest) {
+	reason := req.reason
+	if reason == "" {
+		reason = "room closed"
+	}
+	notice := newMessageEnvelope(&Message{
+		Code:      CodeRoomClosed,
+		Content:   "This room has been closed.",
+		RoomID:    r.ID,
+		Username:  "system",
+		System:    true,
+		Timestamp: time.Now().Format("2006-01-02T15:04:05Z07:00"),
+	})
+	// The web client treats a policy violation as "room expired"
+	closeCode := websocket.ClosePolicyViolation
+	if req.restart {
+		reason = "server restarting"
+		notice = newMessageEnvelope(restartNotice(r.ID))
+		closeCode = websocket.CloseServiceRestart
+	}
+
+	for cl := range r.clients {
+		cl.enqueue(notice)
+		r.removeClient(cl, closeCode, reason)
+	}
+	r.stopPresence()
+	r.stopTyping()
+	r.flushReads()
+	r.core.history.evict(r.ID)
+
+	close(r.done)
+}
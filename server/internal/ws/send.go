@@ -0,0 +1,64 @@
+package ws
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrRoomClosed is returned by Send when the room shuts down before the
+// message is stored
+var ErrRoomClosed = errors.New("room closed")
+
+// sendResult is what an HTTP sender gets back from the room
+type sendResult struct {
+	ack *AckPayload
+	err *ProtocolError
+}
+
+// Send posts a chat message from a sender that isn't connected to the room,
+// such as an HTTP client. It goes through the same checks as a socket frame
+// (content rules, send limits, the profanity filter and client ID dedupe)
+// and returns the ack once the message is stored. Rejections are returned as
+// a *ProtocolError.
+func (r *Room) Send(ctx context.Context, cl *Client, p ChatPayload) (*AckPayload, error) {
+	if protoErr := validateChat(&p); protoErr != nil {
+		return nil, protoErr
+	}
+	if protoErr := r.admit(cl); protoErr != nil {
+		return nil, protoErr
+	}
+
+	reply := make(chan sendResult, 1)
+	in := &Inbound{Client: cl, Envelope: NewEnvelope(EventChat, p), reply: reply}
+	select {
+	case r.inbound <- in:
+	case <-r.done:
+		return nil, ErrRoomClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// The message may still be stored if the caller gives up first; a retry
+	// with the same client ID gets the original back
+	select {
+	case res := <-reply:
+		return res.unwrap()
+	case <-r.done:
+		// Draining for a restart may have stored it on the way out
+		select {
+		case res := <-reply:
+			return res.unwrap()
+		default:
+			return nil, ErrRoomClosed
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (res sendResult) unwrap() (*AckPayload, error) {
+	if res.err != nil {
+		return nil, res.err
+	}
+	return res.ack, nil
+}
@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// stopRequest tells a room goroutine why it is exiting
+type stopRequest struct {
+	reason string
+
+	// restart drains the room for a server shutdown instead of closing it
+	restart  bool
+	deadline <-chan struct{}
+}
+
+// restartNotice is the system message clients get before a graceful shutdown
+func restartNotice(roomID string) *Message {
+	return &Message{
+		Code:      CodeServerRestarting,
+		Content:   "The server is restarting. You'll be reconnected shortly.",
+		RoomID:    roomID,
+		Username:  "system",
+		System:    true,
+		Timestamp: time.Now().Format("2006-01-02T15:04:05Z07:00"),
+	}
+}
+
+// Go runs fn in a goroutine that Shutdown waits for
+func (c *Core) Go(fn func()) {
+	c.tasks.Add(1)
+	go func() {
+		defer c.tasks.Done()
+		fn()
+	}()
+}
+
+// Shutdown drains every live room for a server restart. Messages already
+// accepted are stored and delivered, clients get a restart notice and a
+// CloseServiceRestart close frame, and buffered stats, background writes and
+// queued broker events are flushed. It gives up when ctx is done.
+func (c *Core) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	rooms := make([]*Room, 0, len(c.rooms))
+	for _, room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	c.rooms = make(map[string]*Room)
+	c.mu.Unlock()
+
+	log.Printf("Core.Shutdown - Draining %d rooms", len(rooms))
+	for _, room := range rooms {
+		room.restart(ctx.Done())
+	}
+	for _, room := range rooms {
+		select {
+		case <-room.done:
+		case <-ctx.Done():
+			return fmt.Errorf("drain rooms: %w", ctx.Err())
+		}
+	}
+
+	if err := c.stats.stop(ctx); err != nil {
+		return fmt.Errorf("flush stats: %w", err)
+	}
+
+	// Mention notifications and the clients' final frames
+	finished := make(chan struct{})
+	go func() {
+		c.tasks.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		return fmt.Errorf("wait for background work: %w", ctx.Err())
+	}
+
+	return c.flushOutbox(ctx)
+}
+
+// flushOutbox publishes events still waiting for Run so other instances see
+// the last messages stored here
+func (c *Core) flushOutbox(ctx context.Context) error {
+	for {
+		select {
+		case ev := <-c.outbox:
+			if err := c.broker.Publish(ctx, ev); err != nil {
+				return fmt.Errorf("flush %s event for room %s: %w", ev.Kind, ev.RoomID, err)
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// drain flushes chat accepted before a restart. Frames already queued are
+// dispatched, then the persist queue is closed and every message it stores
+// is fanned out as usual.
+func (r *Room) drain(deadline <-chan struct{}) {
+	for pending := true; pending; {
+		select {
+		case in := <-r.inbound:
+			r.dispatch(in)
+		case m := <-r.broadcast:
+			r.submit(m, nil)
+		default:
+			pending = false
+		}
+	}
+
+	close(r.persistQ)
+	for {
+		select {
+		case p, ok := <-r.persisted:
+			if !ok {
+				return
+			}
+			r.handlePersisted(p)
+		case <-deadline:
+			log.Printf("Room.drain - Gave up flushing messages for room %s", r.ID)
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// How long an EventSource waits before reconnecting after the stream drops
+const streamRetry = 2 * time.Second
+
+// StreamEvents writes the room's events to w as Server-Sent Events until the
+// request ends, the room drops the client or the server shuts down. Each event
+// carries the same envelope a socket would get, named after its type. Stored
+// messages use their sequence number as the event ID, so a reconnecting
+// EventSource resumes from Last-Event-ID. The client must already be
+// registered with the room, and the response headers already sent.
+func (c *Client) StreamEvents(ctx context.Context, room *Room, w http.ResponseWriter) {
+	defer room.Unregister(c)
+
+	rc := http.NewResponseController(w)
+	write := func(fn func() error) bool {
+		rc.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := fn(); err != nil {
+			log.Printf("Client.StreamEvents - Write failed for %s: %v", c.ID, err)
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	if !write(func() error {
+		_, err := fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+		return err
+	}) {
+		return
+	}
+
+	// Comments keep proxies from timing out an idle stream
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case env, ok := <-c.Message:
+			if !ok {
+				return
+			}
+			if !write(func() error { return writeEvent(w, env) }) {
+				return
+			}
+
+		case <-ticker.C:
+			if !write(func() error {
+				_, err := io.WriteString(w, ": ping\n\n")
+				return err
+			}) {
+				return
+			}
+
+		case <-room.core.streamsDone:
+			// Rooms drain after the HTTP server stops, so say goodbye here
+			notice := newMessageEnvelope(restartNotice(c.RoomID))
+			write(func() error { return writeEvent(w, notice) })
+			return
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// writeEvent writes one envelope as a Server-Sent Event
+func writeEvent(w io.Writer, env *Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	if seq := envelopeSeq(env); seq > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", seq); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", env.Type, data)
+	return err
+}
+
+// envelopeSeq returns the sequence number of a stored message, or 0 for
+// everything else
+func envelopeSeq(env *Envelope) int64 {
+	if env.Type != EventChat && env.Type != EventSystem {
+		return 0
+	}
+	var m struct {
+		Seq int64 `json:"seq"`
+	}
+	if err := env.DecodePayload(&m); err != nil {
+		return 0
+	}
+	return m.Seq
+}
+
+// EndStreams ends every Server-Sent Event stream with a restart notice. Streams
+// never go idle, so the HTTP server's shutdown would otherwise wait them out.
+func (c *Core) EndStreams() {
+	c.endStreams.Do(func() { close(c.streamsDone) })
+}
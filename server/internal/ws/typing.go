@@ -0,0 +1,109 @@
+package ws
+
+import (
+	"time"
+
+	"github.com/Melkeydev/yappr/internal/broker"
+	"github.com/Melkeydev/yappr/internal/ratelimit"
+)
+
+const (
+	// Minimum gap between typing-start frames relayed for one client. Starts
+	// inside the gap only keep the indicator alive.
+	typingThrottle = 2 * time.Second
+
+	// A typing indicator is cleared if the client sends nothing for this long
+	typingTimeout = 6 * time.Second
+)
+
+// Typing frames a connection may send. Clients send one per keystroke, so this
+// only stops floods; frames over it are dropped before reaching the room.
+var typingLimit = ratelimit.Limit{Rate: 10, Burst: 20}
+
+// typingState tracks a client that is currently shown as typing. A fresh state
+// is created on every refresh so a stale expiry can be told apart.
+type typingState struct {
+	client *Client
+	timer  *time.Timer
+}
+
+// handleTyping applies a typing start or stop from a client. Typing events are
+// only relayed, never stored or counted as messages.
+func (r *Room) handleTyping(cl *Client, typing bool) {
+	if !typing {
+		r.clearTyping(cl)
+		return
+	}
+
+	now := time.Now()
+	prev, wasTyping := r.typing[cl]
+	if wasTyping {
+		prev.timer.Stop()
+	}
+
+	// The last relayed start outlives a stop, so toggling can't bypass the throttle
+	st := &typingState{client: cl}
+	if now.Sub(r.typingSent[cl]) < typingThrottle {
+		if !wasTyping {
+			// Shown again by the next start after the gap
+			return
+		}
+	} else {
+		r.typingSent[cl] = now
+		r.relayTyping(cl, true)
+	}
+
+	st.timer = time.AfterFunc(typingTimeout, func() {
+		select {
+		case r.typingExpired <- st:
+		case <-r.done:
+		}
+	})
+	r.typing[cl] = st
+}
+
+// clearTyping stops a client's typing indicator if it is shown
+func (r *Room) clearTyping(cl *Client) {
+	st, ok := r.typing[cl]
+	if !ok {
+		return
+	}
+	st.timer.Stop()
+	delete(r.typing, cl)
+	r.relayTyping(cl, false)
+}
+
+// dropTyping clears a departing client's indicator and forgets its throttle
+func (r *Room) dropTyping(cl *Client) {
+	r.clearTyping(cl)
+	delete(r.typingSent, cl)
+}
+
+// expireTyping clears an indicator whose client went silent
+func (r *Room) expireTyping(st *typingState) {
+	if r.typing[st.client] != st {
+		return
+	}
+	delete(r.typing, st.client)
+	r.relayTyping(st.client, false)
+}
+
+// relayTyping tells everyone else in the room, here and on other instances
+func (r *Room) relayTyping(from *Client, typing bool) {
+	out := NewEnvelope(EventTyping, TypingPayload{Typing: typing, UserID: from.ID, Username: from.Username})
+	for cl := range r.clients {
+		if cl != from {
+			r.deliver(cl, out)
+		}
+	}
+	r.core.publish(r.ID, broker.KindEnvelope, out)
+}
+
+// stopTyping cancels every typing timer when the room shuts down
+func (r *Room) stopTyping() {
+	for cl, st := range r.typing {
+		st.timer.Stop()
+		delete(r.typing, cl)
+	}
+	clear(r.typingSent)
+}
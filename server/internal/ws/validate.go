@@ -0,0 +1,55 @@
+package ws
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// Largest frame accepted from a client. Leaves room for JSON escaping of
+	// a maximum length message plus the envelope.
+	maxFrameSize = 32 << 10
+
+	// Limits on chat content after sanitizing
+	MaxContentBytes = 4000
+	MaxContentLines = 30
+)
+
+// SanitizeContent normalizes chat text and checks it against the content
+// rules. Control characters other than newline and tab are removed, as are
+// bidi overrides and isolates that can disguise what a message says.
+func SanitizeContent(content string) (string, *ProtocolError) {
+	if !utf8.ValidString(content) {
+		return "", newProtocolError(ErrCodeInvalidEncoding, "message must be valid UTF-8")
+	}
+
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case unicode.IsControl(r), isBidiControl(r):
+			return -1
+		}
+		return r
+	}, content)
+
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return "", newProtocolError(ErrCodeEmptyMessage, "message can't be empty")
+	}
+	if len(content) > MaxContentBytes {
+		return "", newProtocolError(ErrCodeMessageTooLong, "message is longer than %d bytes", MaxContentBytes)
+	}
+	if lines := strings.Count(content, "\n") + 1; lines > MaxContentLines {
+		return "", newProtocolError(ErrCodeTooManyLines, "message has more than %d lines", MaxContentLines)
+	}
+
+	return content, nil
+}
+
+// isBidiControl matches the explicit embedding, override and isolate characters
+func isBidiControl(r rune) bool {
+	return (r >= '\u202A' && r <= '\u202E') || (r >= '\u2066' && r <= '\u2069')
+}
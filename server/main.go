@@ -3,7 +3,11 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"net/http"
@@ -11,19 +15,34 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/Melkeydev/yappr/db"
 	"github.com/Melkeydev/yappr/db/migrations"
+	"github.com/Melkeydev/yappr/internal/broker"
+	apiTokenHandler "github.com/Melkeydev/yappr/internal/api/handler/apitokens"
 	coreHandler "github.com/Melkeydev/yappr/internal/api/handler/core"
+	dmHandler "github.com/Melkeydev/yappr/internal/api/handler/directmessages"
+	messageHandler "github.com/Melkeydev/yappr/internal/api/handler/messages"
+	notificationHandler "github.com/Melkeydev/yappr/internal/api/handler/notifications"
 	statsHandler "github.com/Melkeydev/yappr/internal/api/handler/stats"
 	userHandler "github.com/Melkeydev/yappr/internal/api/handler/user"
+	notificationRepo "github.com/Melkeydev/yappr/internal/repo/notification"
 	roomRepo "github.com/Melkeydev/yappr/internal/repo/room"
 	statsRepo "github.com/Melkeydev/yappr/internal/repo/stats"
 	repository "github.com/Melkeydev/yappr/internal/repo/user"
+	apiTokenService "github.com/Melkeydev/yappr/internal/service/apitokens"
+	dmService "github.com/Melkeydev/yappr/internal/service/directmessages"
+	messageService "github.com/Melkeydev/yappr/internal/service/messages"
+	notificationService "github.com/Melkeydev/yappr/internal/service/notifications"
 	"github.com/Melkeydev/yappr/internal/service/pinnedrooms"
 	statsService "github.com/Melkeydev/yappr/internal/service/stats"
 	service "github.com/Melkeydev/yappr/internal/service/user"
 	"github.com/Melkeydev/yappr/internal/ws"
+	authmiddleware "github.com/Melkeydev/yappr/middleware"
 	"github.com/Melkeydev/yappr/router"
+	"github.com/Melkeydev/yappr/util"
 )
 
+// How long a shutdown waits for requests, sockets and pending writes to finish
+const shutdownTimeout = 15 * time.Second
+
 func main() {
 	if err := godotenv.Load(); err != nil {
 		log.Println("Warning: .env file not found, using environment variables")
@@ -48,18 +67,44 @@ func main() {
 	// Set up Repositories
 	userRepo := repository.NewUserRepository(dbConn)
 	statsRepository := statsRepo.NewStatsRepository(dbConn)
+	roomRepository := roomRepo.NewRoomRepository(dbConn)
+	notificationRepository := notificationRepo.NewNotificationRepository(dbConn)
+
+	// Set up the broker that fans room events out across instances
+	eventBroker, err := newBroker(dbConn)
+	if err != nil {
+		log.Fatalf("Failed to initialize broker: %v", err)
+	}
+	defer eventBroker.Close()
 
 	// Set up Services
 	userService := service.NewUserService(userRepo)
 	statsServ := statsService.NewStatsService(statsRepository)
-	wsService := ws.NewCore(dbConn)
+	wsService := ws.NewCore(dbConn, eventBroker)
+	messageServ := messageService.NewMessageService(roomRepository, userRepo, wsService)
+	notificationServ := notificationService.NewNotificationService(notificationRepository)
+	dmServ := dmService.NewDirectMessageService(roomRepository, userRepo, wsService)
+	apiTokenServ := apiTokenService.NewAPITokenService(userRepo)
 
 	// Set up Handlers
 	userHandler := userHandler.NewUserHandler(userService)
 	coreHandler := coreHandler.NewCoreHandler(wsService)
 	statsHand := statsHandler.NewStatsHandler(statsServ)
+	messageHand := messageHandler.NewMessageHandler(messageServ)
+	notificationHand := notificationHandler.NewNotificationHandler(notificationServ)
+	dmHand := dmHandler.NewDirectMessageHandler(dmServ)
+	apiTokenHand := apiTokenHandler.NewAPITokenHandler(apiTokenServ)
 
-	go wsService.Run()
+	// Sessions use the JWT cookie, bots and scripts an API token
+	auth := authmiddleware.NewAuth(apiTokenServ)
+
+	// Cancelled on SIGINT/SIGTERM to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	runCtx, stopRun := context.WithCancel(context.Background())
+	defer stopRun()
+	go wsService.Run(runCtx)
 
 	pinnedRoomsService := pinnedrooms.NewPinnedRoomsService(dbConn, wsService)
 	if err := pinnedRoomsService.CheckAndRefreshPinnedRooms(context.Background()); err != nil {
@@ -67,37 +112,95 @@ func main() {
 	}
 
 	// Start background job to clean up expired rooms
-	go startRoomCleanupJob(dbConn, wsService)
+	cleanupDone := make(chan struct{})
+	go func() {
+		defer close(cleanupDone)
+		startRoomCleanupJob(ctx, dbConn, wsService)
+	}()
+
+	router := router.SetupRouter(userHandler, coreHandler, statsHand, messageHand, notificationHand, dmHand, apiTokenHand, auth)
+	srv := &http.Server{Addr: ":8080", Handler: router}
+	// Event streams never go idle, so end them as soon as shutdown starts
+	srv.RegisterOnShutdown(wsService.EndStreams)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("Shutting down, press Ctrl+C again to force")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Stop accepting connections first so no new sockets join rooms being drained
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
 
-	router := router.SetupRouter(userHandler, coreHandler, statsHand)
-	if err := http.ListenAndServe(":8080", router); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	select {
+	case <-cleanupDone:
+	case <-shutdownCtx.Done():
+		log.Println("Room cleanup job did not stop in time")
 	}
+
+	if err := wsService.Shutdown(shutdownCtx); err != nil {
+		log.Printf("WebSocket shutdown: %v", err)
+	}
+	stopRun()
+
+	log.Println("Server stopped")
 }
 
-func startRoomCleanupJob(db *sql.DB, wsCore *ws.Core) {
+// newBroker picks the broker backend from BROKER: "postgres" for multi-instance
+// deployments, anything else for the in-memory single-node broker
+func newBroker(dbConn *sql.DB) (broker.Broker, error) {
+	switch util.GetEnv("BROKER", "memory") {
+	case "postgres":
+		log.Println("Using Postgres LISTEN/NOTIFY broker")
+		return broker.NewPostgresBroker(dbConn, db.DSN())
+	default:
+		log.Println("Using in-memory broker")
+		return broker.NewMemoryBroker(), nil
+	}
+}
+
+// startRoomCleanupJob removes expired rooms every few minutes until ctx is cancelled
+func startRoomCleanupJob(ctx context.Context, db *sql.DB, wsCore *ws.Core) {
 	roomRepository := roomRepo.NewRoomRepository(db)
 	pinnedRoomsService := pinnedrooms.NewPinnedRoomsService(db, wsCore)
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
 
-	cleanupRooms(roomRepository, pinnedRoomsService)
+	cleanupRooms(roomRepository, pinnedRoomsService, wsCore)
 
-	for range ticker.C {
-		cleanupRooms(roomRepository, pinnedRoomsService)
+	for {
+		select {
+		case <-ticker.C:
+			cleanupRooms(roomRepository, pinnedRoomsService, wsCore)
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
-func cleanupRooms(roomRepository *roomRepo.RoomRepository, pinnedRoomsService *pinnedrooms.PinnedRoomsService) {
+func cleanupRooms(roomRepository *roomRepo.RoomRepository, pinnedRoomsService *pinnedrooms.PinnedRoomsService, wsCore *ws.Core) {
 	ctx := context.Background()
-	deletedCount, err := roomRepository.DeleteExpiredRooms(ctx)
+	deletedIDs, err := roomRepository.DeleteExpiredRooms(ctx)
 	if err != nil {
 		log.Printf("Error deleting expired rooms: %v", err)
 		return
 	}
 
-	if deletedCount > 0 {
-		log.Printf("Deleted %d expired rooms", deletedCount)
+	if len(deletedIDs) > 0 {
+		log.Printf("Deleted %d expired rooms", len(deletedIDs))
+	}
+
+	// Disconnect anyone still sitting in a room that just expired
+	for _, id := range deletedIDs {
+		wsCore.CloseRoom(id.String(), "room expired")
 	}
 
 	if err := pinnedRoomsService.CheckAndRefreshPinnedRooms(ctx); err != nil {
@@ -9,8 +9,15 @@ import (
 	"github.com/Melkeydev/yappr/util"
 )
 
-func JWTAuth(next http.Handler) http.Handler {
+func (a *Auth) JWTAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r, handled := a.tokenAuth(w, r); handled {
+			if r != nil {
+				next.ServeHTTP(w, r)
+			}
+			return
+		}
+
 		cookie, err := r.Cookie("jwt")
 		if err != nil {
 			util.WriteError(w, http.StatusUnauthorized, "missing auth token")
@@ -52,10 +59,18 @@ func JWTAuth(next http.Handler) http.Handler {
 	})
 }
 
-func OptionalJWTAuth(next http.Handler) http.Handler {
+func (a *Auth) OptionalJWTAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("OptionalJWTAuth: Processing request to %s", r.URL.Path)
 
+		// A bad API token is an error, not an anonymous request
+		if r, handled := a.tokenAuth(w, r); handled {
+			if r != nil {
+				next.ServeHTTP(w, r)
+			}
+			return
+		}
+
 		cookie, err := r.Cookie("jwt")
 		if err != nil {
 			log.Printf("OptionalJWTAuth: No JWT cookie found: %v", err)
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/Melkeydev/yappr/util"
+)
+
+// TokenVerifier resolves an API token to the account it acts as and its scopes
+type TokenVerifier interface {
+	VerifyToken(ctx context.Context, token string) (userID string, scopes []string, err error)
+}
+
+// Auth authenticates requests with the session cookie or, where a route
+// allows it, an API token sent as "Authorization: Bearer <token>"
+type Auth struct {
+	tokens TokenVerifier
+}
+
+func NewAuth(tokens TokenVerifier) *Auth {
+	return &Auth{tokens: tokens}
+}
+
+// TokenScope lets API tokens with the given scope use the routes it wraps.
+// It must run before JWTAuth or OptionalJWTAuth; routes without it only
+// accept the session cookie.
+func TokenScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), "tokenScope", scope)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// HasScope reports whether the caller may act with scope. Session cookies
+// carry every scope; API tokens only the ones they were created with.
+func HasScope(ctx context.Context, scope string) bool {
+	scopes, ok := ctx.Value("tokenScopes").([]string)
+	if !ok {
+		return true
+	}
+	return slices.Contains(scopes, scope)
+}
+
+// tokenAuth handles a request carrying a bearer token. It reports false when
+// there is none. Otherwise it has either written an error response and
+// returns a nil request, or returns the request with the token's user ID and
+// scopes in its context.
+func (a *Auth) tokenAuth(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return r, false
+	}
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		util.WriteError(w, http.StatusUnauthorized, "malformed Authorization header")
+		return nil, true
+	}
+
+	scope, ok := r.Context().Value("tokenScope").(string)
+	if !ok {
+		util.WriteError(w, http.StatusForbidden, "API tokens can't be used for this endpoint")
+		return nil, true
+	}
+
+	userID, scopes, err := a.tokens.VerifyToken(r.Context(), token)
+	if err != nil {
+		log.Printf("Auth.tokenAuth - Rejected token for %s: %v", r.URL.Path, err)
+		util.WriteError(w, http.StatusUnauthorized, "invalid auth token")
+		return nil, true
+	}
+	if !slices.Contains(scopes, scope) {
+		util.WriteError(w, http.StatusForbidden, "token is missing the "+scope+" scope")
+		return nil, true
+	}
+
+	ctx := context.WithValue(r.Context(), "userID", userID)
+	ctx = context.WithValue(ctx, "tokenScopes", scopes)
+	return r.WithContext(ctx), true
+}
@@ -7,13 +7,18 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 
+	apitokenhandler "github.com/Melkeydev/yappr/internal/api/handler/apitokens"
 	corehandler "github.com/Melkeydev/yappr/internal/api/handler/core"
+	dmhandler "github.com/Melkeydev/yappr/internal/api/handler/directmessages"
+	messagehandler "github.com/Melkeydev/yappr/internal/api/handler/messages"
+	notificationhandler "github.com/Melkeydev/yappr/internal/api/handler/notifications"
 	statshandler "github.com/Melkeydev/yappr/internal/api/handler/stats"
 	userhandler "github.com/Melkeydev/yappr/internal/api/handler/user"
+	"github.com/Melkeydev/yappr/internal/api/model"
 	authmiddleware "github.com/Melkeydev/yappr/middleware"
 )
 
-func SetupRouter(userH *userhandler.UserHandler, coreH *corehandler.CoreHandler, statsH *statshandler.StatsHandler) http.Handler {
+func SetupRouter(userH *userhandler.UserHandler, coreH *corehandler.CoreHandler, statsH *statshandler.StatsHandler, messageH *messagehandler.MessageHandler, notificationH *notificationhandler.NotificationHandler, dmH *dmhandler.DirectMessageHandler, apiTokenH *apitokenhandler.APITokenHandler, auth *authmiddleware.Auth) http.Handler {
 	r := chi.NewRouter()
 
 	r.Use(middleware.Logger)
@@ -22,7 +27,7 @@ func SetupRouter(userH *userhandler.UserHandler, coreH *corehandler.CoreHandler,
 	r.Use(middleware.RealIP)
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:3000", "http://127.0.0.1:3000", "https://yappr.chat", "http://yappr.chat"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
@@ -36,36 +41,107 @@ func SetupRouter(userH *userhandler.UserHandler, coreH *corehandler.CoreHandler,
 
 		// Protected routes
 		u.Group(func(r chi.Router) {
-			r.Use(authmiddleware.JWTAuth)
+			r.Use(auth.JWTAuth)
 			r.Put("/username", userH.UpdateUsername)
+			r.Put("/{userId}/block", dmH.Block)
+			r.Delete("/{userId}/block", dmH.Unblock)
 		})
 	})
 
 	r.Route("/api/stats", func(s chi.Router) {
 		// Protected routes requiring authentication
 		s.Group(func(r chi.Router) {
-			r.Use(authmiddleware.JWTAuth)
+			r.Use(auth.JWTAuth)
 			r.Post("/checkin", statsH.CheckIn)
 			r.Post("/upvote", statsH.GiveUpvote)
 		})
 
 		// Public routes (with optional auth for viewing permissions)
 		s.Group(func(r chi.Router) {
-			r.Use(authmiddleware.OptionalJWTAuth)
+			r.Use(auth.OptionalJWTAuth)
 			r.Get("/profile/{userId}", statsH.GetUserProfile)
 		})
 	})
 
+	r.Route("/api/rooms", func(rm chi.Router) {
+		// Public rooms are readable by anyone, direct rooms only by their members
+		rm.Group(func(r chi.Router) {
+			r.Use(authmiddleware.TokenScope(model.ScopeRoomsRead))
+			r.Use(auth.OptionalJWTAuth)
+			r.Get("/{roomId}/messages", messageH.ListMessages)
+			r.Get("/{roomId}/messages/{messageId}/edits", messageH.ListEdits)
+			r.Get("/{roomId}/messages/{messageId}/thread", messageH.GetThread)
+			// For clients that can't hold a WebSocket; identity works as in /ws/joinRoom
+			r.Get("/{roomId}/events", coreH.StreamEvents)
+		})
+
+		rm.Group(func(r chi.Router) {
+			r.Use(authmiddleware.TokenScope(model.ScopeMessagesWrite))
+			r.Use(auth.OptionalJWTAuth)
+			r.Post("/{roomId}/messages", coreH.SendMessage)
+		})
+
+		rm.Group(func(r chi.Router) {
+			r.Use(authmiddleware.TokenScope(model.ScopeMessagesWrite))
+			r.Use(auth.JWTAuth)
+			r.Patch("/{roomId}/messages/{messageId}", messageH.EditMessage)
+			r.Delete("/{roomId}/messages/{messageId}", messageH.DeleteMessage)
+			r.Put("/{roomId}/messages/{messageId}/reactions/{emoji}", messageH.AddReaction)
+			r.Delete("/{roomId}/messages/{messageId}/reactions/{emoji}", messageH.RemoveReaction)
+			r.Put("/{roomId}/read", messageH.MarkRead)
+		})
+	})
+
+	r.Route("/api/messages", func(m chi.Router) {
+		m.Use(authmiddleware.TokenScope(model.ScopeRoomsRead))
+		m.Use(auth.OptionalJWTAuth)
+		m.Get("/search", messageH.Search)
+	})
+
+	r.Route("/api/dms", func(d chi.Router) {
+		d.Use(auth.JWTAuth)
+		d.Get("/", dmH.ListConversations)
+		d.Post("/", dmH.OpenConversation)
+	})
+
+	r.Route("/api/notifications", func(n chi.Router) {
+		n.Use(auth.JWTAuth)
+		n.Get("/", notificationH.ListNotifications)
+		n.Post("/read", notificationH.MarkRead)
+	})
+
+	// Bots and the tokens they sign in with; only manageable from a session
+	r.Route("/api/bots", func(b chi.Router) {
+		b.Use(auth.JWTAuth)
+		b.Get("/", apiTokenH.ListBots)
+		b.Post("/", apiTokenH.CreateBot)
+	})
+
+	r.Route("/api/tokens", func(t chi.Router) {
+		t.Use(auth.JWTAuth)
+		t.Get("/", apiTokenH.ListTokens)
+		t.Post("/", apiTokenH.CreateToken)
+		t.Delete("/{tokenId}", apiTokenH.RevokeToken)
+	})
+
 	r.Route("/ws", func(u chi.Router) {
 		// Protected route for creating rooms
 		u.Group(func(r chi.Router) {
-			r.Use(authmiddleware.OptionalJWTAuth)
+			r.Use(authmiddleware.TokenScope(model.ScopeRoomsCreate))
+			r.Use(auth.OptionalJWTAuth)
 			r.Post("/createRoom", coreH.CreateRoom)
 		})
 
-		u.Get("/joinRoom/{roomId}", coreH.JoinRoom)
-		u.Get("/getRooms", coreH.GetRooms)
-		u.Get("/getClients/{roomId}", coreH.GetClients)
+		u.Group(func(r chi.Router) {
+			r.Use(authmiddleware.TokenScope(model.ScopeRoomsRead))
+			r.Use(auth.OptionalJWTAuth)
+			// Socket identity is bound to the JWT cookie, falling back to a guest
+			r.Get("/joinRoom/{roomId}", coreH.JoinRoom)
+			// Members of direct rooms are only listed to the two participants
+			r.Get("/getClients/{roomId}", coreH.GetClients)
+			// Signed-in callers also get their unread counts
+			r.Get("/getRooms", coreH.GetRooms)
+		})
 	})
 
 	// simple health